@@ -0,0 +1,438 @@
+package gag
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	gorillaMux "github.com/gorilla/mux"
+)
+
+// AdminConfig contains properties for the admin API server.
+// The admin API exposes the route table, the health of upstreams and the config version,
+// and allows routes to be added, disabled, enabled and removed while Gag is serving.
+//
+// Endpoints:
+//  GET    /routes               lists all routes.
+//  POST   /routes               adds a route to an upstream.
+//  GET    /routes/{id}          returns a route.
+//  DELETE /routes/{id}          removes a route.
+//  POST   /routes/{id}/disable  disables a route, so that it doesn't handle requests.
+//  POST   /routes/{id}/enable   enables a disabled route.
+//  PUT    /routes/{id}/weights  changes the weights of the upstream versions of a route, such as {"stable":90,"canary":10}.
+//  GET    /upstreams            lists the health of upstreams.
+//  GET    /circuits             lists the circuit states of upstreams having a CircuitBreaker.
+//  GET    /config               returns the config version, which increases on each change of routes.
+type AdminConfig struct {
+	// Port defines which port number will be used to listen to admin API requests.
+	// It must differ from Config.Port.
+	Port uint16
+	// Token protects the admin API. Requests must have "Authorization: Bearer <Token>" header.
+	Token string
+	// ReadHeaderTimeout is the maximum duration for reading request headers.
+	// When 0, DefaultAdminReadHeaderTimeout will be used.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is the maximum duration for reading the entire request, including the body.
+	// When 0, DefaultAdminReadTimeout will be used.
+	ReadTimeout time.Duration
+}
+
+// Default timeouts of the admin API server.
+const (
+	DefaultAdminReadHeaderTimeout = 5 * time.Second
+	DefaultAdminReadTimeout       = 10 * time.Second
+)
+
+// AdminCircuit is the representation of the CircuitBreaker of an upstream in the admin API.
+type AdminCircuit struct {
+	Url   string       `json:"url"`
+	State CircuitState `json:"state"`
+	// Routes are the ids of the routes to the upstream.
+	Routes              []int     `json:"routes"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastFailure         time.Time `json:"lastFailure"`
+}
+
+// AdminRoute is the representation of a Condition in the admin API.
+type AdminRoute struct {
+	ID          int               `json:"id"`
+	Path        string            `json:"path"`
+	Method      string            `json:"method,omitempty"`
+	Header      string            `json:"header,omitempty"`
+	HeaderValue *AdminHeaderValue `json:"headerValue,omitempty"`
+	// Type is one of "route", "handler", "composition" and "grpc".
+	Type     string         `json:"type"`
+	Upstream *AdminUpstream `json:"upstream,omitempty"`
+	Disabled bool           `json:"disabled"`
+}
+
+// AdminHeaderValue is the representation of a header key-value pair in the admin API.
+type AdminHeaderValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// AdminUpstream is the representation of a RouteRequest in the admin API.
+type AdminUpstream struct {
+	Url        string `json:"url"`
+	HttpMethod string `json:"httpMethod,omitempty"`
+	// Timeout is formatted as a time.Duration, such as "2s".
+	Timeout         string          `json:"timeout,omitempty"`
+	PassRequestBody bool            `json:"passRequestBody"`
+	Health          *UpstreamHealth `json:"health,omitempty"`
+	Mirror          *MirrorStats    `json:"mirror,omitempty"`
+	Hedge           *HedgeStats     `json:"hedge,omitempty"`
+	// Endpoints are the endpoints of the Url, discovered by RouteRequest.Discovery.
+	Endpoints []string `json:"endpoints,omitempty"`
+	// Versions are the versions of RouteRequest.Split, which the requests are split between.
+	Versions []AdminUpstreamVersion `json:"versions,omitempty"`
+}
+
+// AdminUpstreamVersion is the representation of an UpstreamVersion in the admin API.
+type AdminUpstreamVersion struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// Route types of AdminRoute.
+const (
+	routeTypeRoute       = "route"
+	routeTypeHandler     = "handler"
+	routeTypeComposition = "composition"
+	routeTypeGrpc        = "grpc"
+)
+
+func (g *Gag) serveAdmin() error {
+	if g.cfg.Admin.Token == "" {
+		return errors.New("admin token cannot be \"\"")
+	}
+	if g.cfg.Admin.Port != 0 && g.cfg.Admin.Port == g.cfg.Port {
+		return fmt.Errorf("admin port(%d) cannot be the same as port", g.cfg.Admin.Port)
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", g.cfg.Admin.Port))
+	if err != nil {
+		return err
+	}
+	readHeaderTimeout, readTimeout := g.cfg.Admin.ReadHeaderTimeout, g.cfg.Admin.ReadTimeout
+	if readHeaderTimeout == 0 {
+		readHeaderTimeout = DefaultAdminReadHeaderTimeout
+	}
+	if readTimeout == 0 {
+		readTimeout = DefaultAdminReadTimeout
+	}
+	g.admin = &http.Server{Handler: g.adminHandler(), ReadHeaderTimeout: readHeaderTimeout, ReadTimeout: readTimeout}
+	g.log.Println(fmt.Sprintf("gag admin started on port %d", l.Addr().(*net.TCPAddr).Port))
+	go func() {
+		if err := g.admin.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			g.log.Println(fmt.Sprintf("err in admin Serve(): %s", err.Error()))
+		}
+	}()
+	return nil
+}
+
+func (g *Gag) adminHandler() http.Handler {
+	mux := gorillaMux.NewRouter()
+	mux.HandleFunc("/routes", g.adminListRoutes).Methods(http.MethodGet)
+	mux.HandleFunc("/routes", g.adminAddRoute).Methods(http.MethodPost)
+	mux.HandleFunc("/routes/{id:[0-9]+}", g.adminGetRoute).Methods(http.MethodGet)
+	mux.HandleFunc("/routes/{id:[0-9]+}", g.adminRemoveRoute).Methods(http.MethodDelete)
+	mux.HandleFunc("/routes/{id:[0-9]+}/disable", g.adminSetDisabled(true)).Methods(http.MethodPost)
+	mux.HandleFunc("/routes/{id:[0-9]+}/enable", g.adminSetDisabled(false)).Methods(http.MethodPost)
+	mux.HandleFunc("/routes/{id:[0-9]+}/weights", g.adminSetWeights).Methods(http.MethodPut)
+	mux.HandleFunc("/upstreams", g.adminListUpstreams).Methods(http.MethodGet)
+	mux.HandleFunc("/circuits", g.adminListCircuits).Methods(http.MethodGet)
+	mux.HandleFunc("/config", g.adminConfig).Methods(http.MethodGet)
+
+	token := []byte("Bearer " + g.cfg.Admin.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("401 unauthorized"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (g *Gag) adminListRoutes(w http.ResponseWriter, r *http.Request) {
+	g.mu.RLock()
+	routes := make([]AdminRoute, 0, len(g.conditions))
+	for _, c := range g.conditions {
+		routes = append(routes, c.adminRoute())
+	}
+	g.mu.RUnlock()
+	writeJSON(w, r, http.StatusOK, routes)
+}
+
+func (g *Gag) adminGetRoute(w http.ResponseWriter, r *http.Request) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, c := g.findCondition(r)
+	if c == nil {
+		respond404(w, r)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, c.adminRoute())
+}
+
+func (g *Gag) adminAddRoute(w http.ResponseWriter, r *http.Request) {
+	var route AdminRoute
+	if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+		respond400(w, r, err)
+		return
+	}
+	c, err := route.condition()
+	if err != nil {
+		respond400(w, r, err)
+		return
+	}
+
+	g.mu.Lock()
+	candidates := append(g.conditions[:len(g.conditions):len(g.conditions)], c)
+	if err := validateConditions(candidates); err != nil {
+		g.mu.Unlock()
+		respond409(w, r, err)
+		return
+	}
+	g.conditions = candidates
+	g.configureHandlerLocked()
+	added := c.adminRoute()
+	g.mu.Unlock()
+	writeJSON(w, r, http.StatusCreated, added)
+}
+
+func (g *Gag) adminRemoveRoute(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	i, c := g.findCondition(r)
+	if c == nil {
+		respond404(w, r)
+		return
+	}
+	g.conditions = append(g.conditions[:i:i], g.conditions[i+1:]...)
+	g.configureHandlerLocked()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gag) adminSetDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		_, c := g.findCondition(r)
+		if c == nil {
+			respond404(w, r)
+			return
+		}
+		if c.disabled != disabled {
+			c.disabled = disabled
+			g.configureHandlerLocked()
+		}
+		writeJSON(w, r, http.StatusOK, c.adminRoute())
+	}
+}
+
+func (g *Gag) adminSetWeights(w http.ResponseWriter, r *http.Request) {
+	var weights map[string]int
+	if err := json.NewDecoder(r.Body).Decode(&weights); err != nil {
+		respond400(w, r, err)
+		return
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, c := g.findCondition(r)
+	if c == nil {
+		respond404(w, r)
+		return
+	}
+	if c.routeRequest == nil || c.routeRequest.Split == nil {
+		respond400(w, r, errors.New("route has no upstream versions"))
+		return
+	}
+	if err := c.routeRequest.Split.SetWeights(weights); err != nil {
+		respond400(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, c.adminRoute())
+}
+
+func (g *Gag) adminListUpstreams(w http.ResponseWriter, r *http.Request) {
+	g.mu.RLock()
+	upstreams := []AdminUpstream{}
+	seen := map[*RouteRequest]bool{}
+	for _, c := range g.conditions {
+		if c.routeRequest == nil || seen[c.routeRequest] {
+			continue
+		}
+		seen[c.routeRequest] = true
+		upstreams = append(upstreams, *c.adminRoute().Upstream)
+	}
+	g.mu.RUnlock()
+	writeJSON(w, r, http.StatusOK, upstreams)
+}
+
+func (g *Gag) adminListCircuits(w http.ResponseWriter, r *http.Request) {
+	g.mu.RLock()
+	circuits := []*AdminCircuit{}
+	byRoute := map[*RouteRequest]*AdminCircuit{}
+	for _, c := range g.conditions {
+		if c.routeRequest == nil || c.routeRequest.CircuitBreaker == nil {
+			continue
+		}
+		if circuit, ok := byRoute[c.routeRequest]; ok {
+			circuit.Routes = append(circuit.Routes, c.id)
+			continue
+		}
+		health := c.routeRequest.Health()
+		circuit := &AdminCircuit{
+			Url:                 c.routeRequest.Url,
+			State:               health.Circuit,
+			Routes:              []int{c.id},
+			ConsecutiveFailures: health.ConsecutiveFailures,
+			LastFailure:         health.LastFailure,
+		}
+		byRoute[c.routeRequest] = circuit
+		circuits = append(circuits, circuit)
+	}
+	g.mu.RUnlock()
+	writeJSON(w, r, http.StatusOK, circuits)
+}
+
+func (g *Gag) adminConfig(w http.ResponseWriter, r *http.Request) {
+	g.mu.RLock()
+	version := g.version
+	g.mu.RUnlock()
+	writeJSON(w, r, http.StatusOK, map[string]int{"version": version})
+}
+
+// findCondition returns the Condition identified by the id path variable of r, and its index.
+// g.mu must be held.
+func (g *Gag) findCondition(r *http.Request) (int, *Condition) {
+	id, err := strconv.Atoi(gorillaMux.Vars(r)["id"])
+	if err != nil {
+		return -1, nil
+	}
+	for i, c := range g.conditions {
+		if c.id == id {
+			return i, c
+		}
+	}
+	return -1, nil
+}
+
+func (c *Condition) adminRoute() AdminRoute {
+	route := AdminRoute{
+		ID:       c.id,
+		Path:     c.path,
+		Method:   c.httpMethod,
+		Header:   c.header,
+		Disabled: c.disabled,
+	}
+	if c.headerValue != nil {
+		route.HeaderValue = &AdminHeaderValue{Key: c.headerValue.Key, Value: c.headerValue.Value}
+	}
+	switch {
+	case c.handlerFunc != nil:
+		route.Type = routeTypeHandler
+	case c.composition != nil:
+		route.Type = routeTypeComposition
+	case c.grpcRoute != nil:
+		route.Type = routeTypeGrpc
+	case c.routeRequest != nil:
+		route.Type = routeTypeRoute
+		health := c.routeRequest.Health()
+		route.Upstream = &AdminUpstream{
+			Url:             c.routeRequest.Url,
+			HttpMethod:      c.routeRequest.HttpMethod,
+			PassRequestBody: c.routeRequest.PassRequestBody,
+			Health:          &health,
+		}
+		if c.routeRequest.Timeout > 0 {
+			route.Upstream.Timeout = c.routeRequest.Timeout.String()
+		}
+		if c.routeRequest.Mirror != nil {
+			stats := c.routeRequest.Mirror.Stats()
+			route.Upstream.Mirror = &stats
+		}
+		if c.routeRequest.Hedge != nil {
+			stats := c.routeRequest.Hedge.Stats()
+			route.Upstream.Hedge = &stats
+		}
+		if d := c.routeRequest.Discovery; d != nil {
+			route.Upstream.Endpoints = d.Endpoints()
+		}
+		if s := c.routeRequest.Split; s != nil {
+			weights := s.Weights()
+			for _, v := range s.Versions {
+				route.Upstream.Versions = append(route.Upstream.Versions, AdminUpstreamVersion{Name: v.Name, Url: v.Url, Weight: weights[v.Name]})
+			}
+		}
+	}
+	return route
+}
+
+// condition validates route and returns the Condition it describes.
+// Only routes to upstreams can be added through the admin API.
+func (route AdminRoute) condition() (*Condition, error) {
+	if route.Path == "" {
+		return nil, errors.New("path cannot be \"\"")
+	}
+	if route.Method != "" && !isValidMethod(route.Method) {
+		return nil, fmt.Errorf("method(%s) is invalid", route.Method)
+	}
+	if route.Upstream == nil {
+		return nil, errors.New("upstream cannot be empty")
+	}
+	u, err := url.Parse(route.Upstream.Url)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("upstream url(%s) is invalid", route.Upstream.Url)
+	}
+	if route.Upstream.HttpMethod != "" && !isValidMethod(route.Upstream.HttpMethod) {
+		return nil, fmt.Errorf("upstream method(%s) is invalid", route.Upstream.HttpMethod)
+	}
+	var timeout time.Duration
+	if route.Upstream.Timeout != "" {
+		timeout, err = time.ParseDuration(route.Upstream.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("upstream timeout(%s) is invalid", route.Upstream.Timeout)
+		}
+	}
+	if err := gorillaMux.NewRouter().Path(route.Path).GetError(); err != nil {
+		return nil, fmt.Errorf("path(%s) is invalid: %s", route.Path, err.Error())
+	}
+
+	c := &Condition{
+		path:       route.Path,
+		httpMethod: route.Method,
+		header:     route.Header,
+		disabled:   route.Disabled,
+		routeRequest: &RouteRequest{
+			Url:             route.Upstream.Url,
+			HttpMethod:      route.Upstream.HttpMethod,
+			Timeout:         timeout,
+			PassRequestBody: route.Upstream.PassRequestBody,
+		},
+	}
+	if route.HeaderValue != nil {
+		c.headerValue = &headerValue{route.HeaderValue.Key, route.HeaderValue.Value}
+	}
+	return c, nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		respond500(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(res)
+}
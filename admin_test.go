@@ -0,0 +1,218 @@
+package gag
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func doAdmin(t *testing.T, url string, method string, path string, body string) *http.Response {
+	r, err := http.NewRequest(method, url+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	return res
+}
+
+func decodeJSON(t *testing.T, res *http.Response, v interface{}) {
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	g := NewGag(Config{Admin: &AdminConfig{Token: "secret"}})
+	g.configureHandler()
+	admin := httptest.NewServer(g.adminHandler())
+	defer admin.Close()
+
+	res, err := http.Get(admin.URL + "/routes")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	if err := validateResponse(res, http.StatusUnauthorized, "401 unauthorized"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdminManagesRoutes(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{"message":"upstream"}`))
+	defer upstream.Close()
+
+	g := NewGag(Config{Admin: &AdminConfig{Token: "secret"}})
+	g.Conditions().Path("/a").Method(http.MethodGet).HandlerFunc(sampleHandler(), g)
+	s := newTestServer(g)
+	defer s.Close()
+	admin := httptest.NewServer(g.adminHandler())
+	defer admin.Close()
+
+	var routes []AdminRoute
+	decodeJSON(t, doAdmin(t, admin.URL, http.MethodGet, "/routes", ""), &routes)
+	if len(routes) != 1 || routes[0].Path != "/a" || routes[0].Type != routeTypeHandler {
+		t.Errorf("expected handler route /a, got %+v", routes)
+		return
+	}
+
+	res := doAdmin(t, admin.URL, http.MethodPost, "/routes", `{"path":"/b","upstream":{"url":"not a url"}}`)
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status code %d for invalid route, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+
+	var added AdminRoute
+	res = doAdmin(t, admin.URL, http.MethodPost, "/routes", fmt.Sprintf(`{"path":"/b","method":"GET","upstream":{"url":"%s","httpMethod":"GET","timeout":"1s"}}`, upstream.URL))
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("expected status code %d, got %d", http.StatusCreated, res.StatusCode)
+		return
+	}
+	decodeJSON(t, res, &added)
+
+	res, err := http.Get(s.URL + "/b")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	if err := validateResponse(res, http.StatusOK, `{"message":"upstream"}`); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var upstreams []AdminUpstream
+	decodeJSON(t, doAdmin(t, admin.URL, http.MethodGet, "/upstreams", ""), &upstreams)
+	if len(upstreams) != 1 || !upstreams[0].Health.Healthy || upstreams[0].Health.LastSuccess.IsZero() {
+		t.Errorf("expected a healthy upstream, got %+v", upstreams)
+	}
+
+	path := fmt.Sprintf("/routes/%d", added.ID)
+	doAdmin(t, admin.URL, http.MethodPost, path+"/disable", "").Body.Close()
+	res, err = http.Get(s.URL + "/b")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status code %d for disabled route, got %d", http.StatusNotFound, res.StatusCode)
+	}
+
+	doAdmin(t, admin.URL, http.MethodPost, path+"/enable", "").Body.Close()
+	res, err = http.Get(s.URL + "/b")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d for enabled route, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	res = doAdmin(t, admin.URL, http.MethodDelete, path, "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status code %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	res = doAdmin(t, admin.URL, http.MethodGet, path, "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status code %d for removed route, got %d", http.StatusNotFound, res.StatusCode)
+	}
+
+	var cfg map[string]int
+	decodeJSON(t, doAdmin(t, admin.URL, http.MethodGet, "/config", ""), &cfg)
+	// Initial configuration, add, disable, enable and remove.
+	if cfg["version"] != 5 {
+		t.Errorf("expected config version %d, got %d", 5, cfg["version"])
+	}
+}
+
+func TestAdminRequiresConfiguredToken(t *testing.T) {
+	g := NewGag(Config{Admin: &AdminConfig{}})
+	if err := g.Serve(); err == nil {
+		t.Error("expected error when admin token is empty")
+	}
+}
+
+func TestAdminClosedWhenServeFails(t *testing.T) {
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer taken.Close()
+	free, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	adminPort := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	g := NewGag(Config{Port: uint16(taken.Addr().(*net.TCPAddr).Port), Admin: &AdminConfig{Token: "secret", Port: uint16(adminPort)}})
+	if err := g.Serve(); err == nil {
+		t.Fatal("expected error when the port is in use")
+	}
+	if conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", adminPort)); err == nil {
+		conn.Close()
+		t.Error("expected admin API to be closed after Serve failed")
+	}
+}
+
+func TestAdminListsCircuits(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{"message":"upstream"}`))
+	defer upstream.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	g := NewGag(Config{Admin: &AdminConfig{Token: "secret"}})
+	g.Conditions().
+		Path("/plain").Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}, g).
+		Path("/up").Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet, CircuitBreaker: &CircuitBreaker{}}, g).
+		Path("/down").Route(&RouteRequest{Url: down.URL, HttpMethod: http.MethodGet, CircuitBreaker: &CircuitBreaker{FailureThreshold: 2, OpenDuration: 100 * time.Millisecond}}, g)
+	s := newTestServer(g)
+	defer s.Close()
+	admin := httptest.NewServer(g.adminHandler())
+	defer admin.Close()
+
+	for _, path := range []string{"/plain", "/up", "/down", "/down"} {
+		res, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		res.Body.Close()
+	}
+	for _, expected := range []CircuitState{CircuitOpen, CircuitHalfOpen} {
+		var circuits []AdminCircuit
+		decodeJSON(t, doAdmin(t, admin.URL, http.MethodGet, "/circuits", ""), &circuits)
+		if len(circuits) != 2 {
+			t.Fatalf("expected 2 circuits, got %+v", circuits)
+		}
+		if circuits[0].State != CircuitClosed || circuits[0].Url != upstream.URL {
+			t.Errorf("expected closed circuit of %s, got %+v", upstream.URL, circuits[0])
+		}
+		if circuits[1].State != expected || circuits[1].ConsecutiveFailures != 2 || circuits[1].Routes[0] != 3 {
+			t.Errorf("expected %s circuit of route 3, got %+v", expected, circuits[1])
+		}
+		time.Sleep(150 * time.Millisecond)
+	}
+}
+
+func TestAdminServerTimeouts(t *testing.T) {
+	g := NewGag(Config{Admin: &AdminConfig{Token: "secret", ReadTimeout: time.Minute}})
+	if err := g.serveAdmin(); err != nil {
+		t.Fatalf("error serving admin API: %v", err)
+	}
+	defer g.admin.Close()
+	if g.admin.ReadHeaderTimeout != DefaultAdminReadHeaderTimeout || g.admin.ReadTimeout != time.Minute {
+		t.Errorf("expected admin timeouts %v and %v, got %v and %v", DefaultAdminReadHeaderTimeout, time.Minute, g.admin.ReadHeaderTimeout, g.admin.ReadTimeout)
+	}
+}
@@ -0,0 +1,459 @@
+package gag
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheMaxBytes is the size limit of the LRUStore created when CacheConfig.Store is nil.
+const DefaultCacheMaxBytes = 64 << 20
+
+// CacheConfig contains properties for Cache.
+type CacheConfig struct {
+	// Store is where the cached responses are kept.
+	// When nil, an LRUStore bounded by DefaultCacheMaxBytes will be used.
+	Store CacheStore
+	// DefaultTTL is how long a response is fresh when the upstream doesn't
+	// provide Cache-Control max-age or Expires headers.
+	// When 0, such responses are cached only if they can be revalidated with ETag or Last-Modified.
+	DefaultTTL time.Duration
+}
+
+// Cache is an HTTP cache for the responses of RouteRequest routes.
+// It honors Cache-Control, Expires, ETag, Last-Modified and Vary headers of the upstream response,
+// revalidates stale responses with If-None-Match and If-Modified-Since,
+// and coalesces concurrent requests for the same response into a single upstream request.
+// A Cache can be shared by multiple RouteRequests.
+type Cache struct {
+	store      CacheStore
+	defaultTTL time.Duration
+	flights    flightGroup
+}
+
+// CacheStore stores cached responses by key.
+// Implementations must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns the response stored for key.
+	Get(key string) (*CachedResponse, bool)
+	// Set stores res for key.
+	Set(key string, res *CachedResponse)
+	// Delete removes the response stored for key.
+	Delete(key string)
+}
+
+// CachedResponse is an upstream response stored in a CacheStore.
+// A CachedResponse must not be modified after it is stored.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// StoredAt is when the response was received or last revalidated.
+	StoredAt time.Time
+	// MaxAge is how long the response is fresh since StoredAt.
+	MaxAge time.Duration
+	// StaleWhileRevalidate is how long the response can be served after it became stale,
+	// while it is revalidated in the background.
+	StaleWhileRevalidate time.Duration
+	// NoCache determines whether the response must be revalidated before each use.
+	NoCache bool
+	// Vary are the request headers which the response varies by.
+	// A response varying by headers is stored under a key including their values, along with a response
+	// having only Vary under the key of the request without them, so that the Vary headers are evicted
+	// along with the responses.
+	Vary []string
+}
+
+// Cache statuses written to the X-Cache response header.
+const (
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheStale       = "STALE"
+	cacheRevalidated = "REVALIDATED"
+)
+
+// cacheableStatusCodes are the status codes of responses which can be cached.
+var cacheableStatusCodes = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// NewCache returns a new Cache instance.
+func NewCache(cfg CacheConfig) *Cache {
+	store := cfg.Store
+	if store == nil {
+		store = NewLRUStore(DefaultCacheMaxBytes)
+	}
+	return &Cache{
+		store:      store,
+		defaultTTL: cfg.DefaultTTL,
+	}
+}
+
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, rr *RouteRequest, uw upstreamWriter) {
+	target, err := rr.target(r)
+	if err != nil {
+		respond500(w, r, err)
+		return
+	}
+	primary := rr.HttpMethod + " " + target + " " + r.URL.RequestURI()
+	key := c.variantKey(primary, r.Header)
+
+	entry, ok := c.store.Get(key)
+	if ok && !entry.NoCache {
+		age := time.Since(entry.StoredAt)
+		if age < entry.MaxAge {
+			c.write(w, r, entry, cacheHit, uw)
+			return
+		}
+		if age < entry.MaxAge+entry.StaleWhileRevalidate {
+			// The revalidation outlives r, but keeps its path variables and upstream version, which determine the url.
+			bg := r.Clone(context.WithoutCancel(r.Context()))
+			bg.Body = http.NoBody
+			go c.fetch(bg, rr, primary, key, entry)
+			c.write(w, r, entry, cacheStale, uw)
+			return
+		}
+	}
+	if !ok {
+		entry = nil
+	}
+
+	res, status, err := c.fetch(r, rr, primary, key, entry)
+	if err != nil {
+		respondUpstreamError(w, r, err)
+		return
+	}
+	c.write(w, r, res, status, uw)
+}
+
+// fetch requests the response for key from the upstream, revalidating entry if not nil.
+// Concurrent fetches of the same key are coalesced into a single upstream request.
+func (c *Cache) fetch(r *http.Request, rr *RouteRequest, primary, key string, entry *CachedResponse) (*CachedResponse, string, error) {
+	return c.flights.do(key, func() (*CachedResponse, string, error) {
+		header := http.Header{}
+		if entry != nil {
+			if etag := entry.Header.Get("ETag"); etag != "" {
+				header.Set("If-None-Match", etag)
+			}
+			if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+				header.Set("If-Modified-Since", lastModified)
+			}
+		}
+
+		// The upstream request is detached from r, since other requests may be waiting for it.
+		res, err := rr.roundTrip(context.Background(), r, header)
+		if err != nil {
+			return nil, "", err
+		}
+
+		now := time.Now()
+		if res.StatusCode == http.StatusNotModified && entry != nil {
+			revalidated := *entry
+			revalidated.Header = entry.Header.Clone()
+			for _, k := range []string{"Cache-Control", "Expires", "ETag", "Last-Modified", "Date"} {
+				if v := res.Header.Get(k); v != "" {
+					revalidated.Header.Set(k, v)
+				}
+			}
+			revalidated.StoredAt = now
+			revalidated.MaxAge, revalidated.StaleWhileRevalidate, revalidated.NoCache, _ = c.freshness(revalidated.Header, now)
+			c.store.Set(key, &revalidated)
+			return &revalidated, cacheRevalidated, nil
+		}
+
+		cached := &CachedResponse{
+			StatusCode: res.StatusCode,
+			Header:     res.Header.Clone(),
+			Body:       res.Body,
+			StoredAt:   now,
+		}
+		var cacheable bool
+		cached.MaxAge, cached.StaleWhileRevalidate, cached.NoCache, cacheable = c.freshness(res.Header, now)
+		vary := parseVary(res.Header)
+		if !cacheable || !cacheableStatusCodes[res.StatusCode] || hasToken(vary, "*") {
+			c.store.Delete(key)
+			return cached, cacheMiss, nil
+		}
+
+		if len(vary) > 0 {
+			cached.Vary = vary
+			c.store.Set(primary, &CachedResponse{StoredAt: now, Vary: vary})
+		}
+		c.store.Set(variantKey(primary, vary, r.Header), cached)
+		return cached, cacheMiss, nil
+	})
+}
+
+// variantKey returns the key of the response for primary, which varies by the request header.
+func (c *Cache) variantKey(primary string, header http.Header) string {
+	var vary []string
+	if entry, ok := c.store.Get(primary); ok {
+		vary = entry.Vary
+	}
+	return variantKey(primary, vary, header)
+}
+
+// variantKey returns the key of the response for primary, which varies by the values of the vary headers of header.
+func variantKey(primary string, vary []string, header http.Header) string {
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range vary {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// freshness returns how long a response having header is fresh, and whether it is cacheable.
+func (c *Cache) freshness(header http.Header, now time.Time) (maxAge, staleWhileRevalidate time.Duration, noCache bool, cacheable bool) {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return 0, 0, false, false
+	}
+	if _, ok := cc["private"]; ok {
+		return 0, 0, false, false
+	}
+	_, noCache = cc["no-cache"]
+	if v, ok := cc["stale-while-revalidate"]; ok {
+		staleWhileRevalidate = parseSeconds(v)
+	}
+
+	hasValidator := header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+	if v, ok := cc["s-maxage"]; ok {
+		return parseSeconds(v), staleWhileRevalidate, noCache, true
+	}
+	if v, ok := cc["max-age"]; ok {
+		return parseSeconds(v), staleWhileRevalidate, noCache, true
+	}
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// Invalid Expires values, such as "0", mean the response is already expired.
+			return 0, staleWhileRevalidate, noCache, hasValidator
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		if expires.Before(date) {
+			return 0, staleWhileRevalidate, noCache, hasValidator
+		}
+		return expires.Sub(date), staleWhileRevalidate, noCache, true
+	}
+	if c.defaultTTL > 0 {
+		return c.defaultTTL, staleWhileRevalidate, noCache, true
+	}
+	return 0, staleWhileRevalidate, noCache, hasValidator
+}
+
+func (c *Cache) write(w http.ResponseWriter, r *http.Request, res *CachedResponse, status string, uw upstreamWriter) {
+	for _, k := range []string{"Cache-Control", "Expires", "ETag", "Last-Modified", "Vary"} {
+		if v := res.Header.Get(k); v != "" {
+			w.Header().Set(k, v)
+		}
+	}
+	w.Header().Set("X-Cache", status)
+	if status != cacheMiss {
+		w.Header().Set("Age", strconv.Itoa(int(time.Since(res.StoredAt).Seconds())))
+	}
+
+	if etag := res.Header.Get("ETag"); etag != "" && res.StatusCode == http.StatusOK {
+		if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	uw.write(w, r, &UpstreamResponse{StatusCode: res.StatusCode, Header: res.Header.Clone(), Body: res.Body})
+}
+
+func (res *CachedResponse) size() int64 {
+	size := int64(len(res.Body))
+	for k, values := range res.Header {
+		for _, v := range values {
+			size += int64(len(k) + len(v))
+		}
+	}
+	for _, name := range res.Vary {
+		size += int64(len(name))
+	}
+	return size
+}
+
+// parseCacheControl returns the directives of a Cache-Control header value, keyed by lower-cased name.
+func parseCacheControl(value string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, arg = part[:i], strings.Trim(part[i+1:], "\"")
+		}
+		directives[strings.ToLower(name)] = arg
+	}
+	return directives
+}
+
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func parseVary(header http.Header) []string {
+	var vary []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return vary
+}
+
+func hasToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether etag is listed in the If-None-Match header value inm, using weak comparison.
+func etagMatches(inm string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// flight is an upstream request of a Cache, which may be shared by concurrent requests.
+type flight struct {
+	wg     sync.WaitGroup
+	res    *CachedResponse
+	status string
+	err    error
+}
+
+// flightGroup coalesces concurrent calls having the same key.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func (fg *flightGroup) do(key string, fn func() (*CachedResponse, string, error)) (*CachedResponse, string, error) {
+	fg.mu.Lock()
+	if fg.flights == nil {
+		fg.flights = map[string]*flight{}
+	}
+	if f, ok := fg.flights[key]; ok {
+		fg.mu.Unlock()
+		f.wg.Wait()
+		return f.res, f.status, f.err
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	fg.flights[key] = f
+	fg.mu.Unlock()
+
+	f.res, f.status, f.err = fn()
+	f.wg.Done()
+
+	fg.mu.Lock()
+	delete(fg.flights, key)
+	fg.mu.Unlock()
+	return f.res, f.status, f.err
+}
+
+// LRUStore is an in-memory CacheStore bounded by the total size of the stored responses.
+// When the bound is exceeded, least recently used responses are evicted.
+type LRUStore struct {
+	maxBytes int64
+	size     int64
+	mu       sync.Mutex
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key  string
+	res  *CachedResponse
+	size int64
+}
+
+// NewLRUStore returns a new LRUStore instance which holds at most maxBytes of responses.
+func NewLRUStore(maxBytes int64) *LRUStore {
+	return &LRUStore{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// Get returns the response stored for key.
+func (s *LRUStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).res, true
+}
+
+// Set stores res for key, evicting least recently used responses if needed.
+// Responses larger than the bound of s are not stored.
+func (s *LRUStore) Set(key string, res *CachedResponse) {
+	size := int64(len(key)) + res.size()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.remove(e)
+	}
+	if size > s.maxBytes {
+		return
+	}
+	s.items[key] = s.ll.PushFront(&lruEntry{key: key, res: res, size: size})
+	s.size += size
+	for s.size > s.maxBytes {
+		s.remove(s.ll.Back())
+	}
+}
+
+// Delete removes the response stored for key.
+func (s *LRUStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.remove(e)
+	}
+}
+
+func (s *LRUStore) remove(e *list.Element) {
+	entry := e.Value.(*lruEntry)
+	s.ll.Remove(e)
+	delete(s.items, entry.key)
+	s.size -= entry.size
+}
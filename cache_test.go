@@ -0,0 +1,305 @@
+package gag
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCacheTestServer(upstream http.HandlerFunc, cache *Cache) (*httptest.Server, func()) {
+	u := httptest.NewServer(upstream)
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/cached").Method(http.MethodGet).Route(&RouteRequest{Url: u.URL, HttpMethod: http.MethodGet, Cache: cache}, g)
+	s := newTestServer(g)
+	return s, func() {
+		s.Close()
+		u.Close()
+	}
+}
+
+func TestCacheMaxAgeHit(t *testing.T) {
+	var hits int32
+	s, closeAll := newCacheTestServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(`{"message":"cached"}`))
+	}, NewCache(CacheConfig{}))
+	defer closeAll()
+
+	for i, expected := range []string{cacheMiss, cacheHit} {
+		res, err := http.Get(s.URL + "/cached")
+		if err != nil {
+			t.Errorf("error doing request: %v", err)
+			return
+		}
+		if res.Header.Get("X-Cache") != expected {
+			t.Errorf("request %d: expected X-Cache %s, got %s", i, expected, res.Header.Get("X-Cache"))
+		}
+		if err := validateResponse(res, http.StatusOK, `{"message":"cached"}`); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("expected upstream hits %d, got %d", 1, hits)
+	}
+}
+
+func TestCacheNoStoreBypass(t *testing.T) {
+	var hits int32
+	s, closeAll := newCacheTestServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(`{}`))
+	}, NewCache(CacheConfig{DefaultTTL: time.Minute}))
+	defer closeAll()
+
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(s.URL + "/cached")
+		if err != nil {
+			t.Errorf("error doing request: %v", err)
+			return
+		}
+		res.Body.Close()
+	}
+
+	if hits != 2 {
+		t.Errorf("expected upstream hits %d, got %d", 2, hits)
+	}
+}
+
+func TestCacheETagRevalidation(t *testing.T) {
+	var hits, notModified int32
+	s, closeAll := newCacheTestServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`{"version":1}`))
+	}, NewCache(CacheConfig{}))
+	defer closeAll()
+
+	for i, expected := range []string{cacheMiss, cacheRevalidated} {
+		res, err := http.Get(s.URL + "/cached")
+		if err != nil {
+			t.Errorf("error doing request: %v", err)
+			return
+		}
+		if res.Header.Get("X-Cache") != expected {
+			t.Errorf("request %d: expected X-Cache %s, got %s", i, expected, res.Header.Get("X-Cache"))
+		}
+		if err := validateResponse(res, http.StatusOK, `{"version":1}`); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if hits != 2 || notModified != 1 {
+		t.Errorf("expected upstream hits %d and 304 responses %d, got %d and %d", 2, 1, hits, notModified)
+	}
+
+	r, err := http.NewRequest(http.MethodGet, s.URL+"/cached", nil)
+	if err != nil {
+		t.Errorf("error creating request: %v", err)
+		return
+	}
+	r.Header.Set("If-None-Match", `"v1"`)
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	if err := validateResponse(res, http.StatusNotModified, ""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var hits int32
+	s, closeAll := newCacheTestServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		w.Write([]byte(`{}`))
+	}, NewCache(CacheConfig{}))
+	defer closeAll()
+
+	for i, expected := range []string{cacheMiss, cacheStale} {
+		res, err := http.Get(s.URL + "/cached")
+		if err != nil {
+			t.Errorf("error doing request: %v", err)
+			return
+		}
+		res.Body.Close()
+		if res.Header.Get("X-Cache") != expected {
+			t.Errorf("request %d: expected X-Cache %s, got %s", i, expected, res.Header.Get("X-Cache"))
+		}
+	}
+
+	for i := 0; i < 100 && atomic.LoadInt32(&hits) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("expected background revalidation, got upstream hits %d", hits)
+	}
+}
+
+func TestCacheCoalescesConcurrentMisses(t *testing.T) {
+	var hits int32
+	s, closeAll := newCacheTestServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(`{}`))
+	}, NewCache(CacheConfig{}))
+	defer closeAll()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := http.Get(s.URL + "/cached")
+			if err != nil {
+				t.Errorf("error doing request: %v", err)
+				return
+			}
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if hits != 1 {
+		t.Errorf("expected upstream hits %d, got %d", 1, hits)
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	var hits int32
+	s, closeAll := newCacheTestServer(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(fmt.Sprintf(`{"n":%d}`, n)))
+	}, NewCache(CacheConfig{}))
+	defer closeAll()
+
+	expected := map[string]string{"en": `{"n":1}`, "ko": `{"n":2}`}
+	for _, lang := range []string{"en", "ko", "en", "ko"} {
+		r, err := http.NewRequest(http.MethodGet, s.URL+"/cached", nil)
+		if err != nil {
+			t.Errorf("error creating request: %v", err)
+			return
+		}
+		r.Header.Set("Accept-Language", lang)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("error doing request: %v", err)
+			return
+		}
+		if err := validateResponse(res, http.StatusOK, expected[lang]); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if hits != 2 {
+		t.Errorf("expected upstream hits %d, got %d", 2, hits)
+	}
+}
+
+func TestLRUStoreEviction(t *testing.T) {
+	s := NewLRUStore(10)
+	s.Set("a", &CachedResponse{Body: []byte("1234")})
+	s.Set("b", &CachedResponse{Body: []byte("1234")})
+	s.Get("a")
+	s.Set("c", &CachedResponse{Body: []byte("1234")})
+
+	if _, ok := s.Get("b"); ok {
+		t.Error("expected least recently used response to be evicted")
+	}
+	if _, ok := s.Get("a"); !ok {
+		t.Error("expected recently used response to be kept")
+	}
+	s.Set("d", &CachedResponse{Body: []byte("12345678910")})
+	if _, ok := s.Get("d"); ok {
+		t.Error("expected response larger than the bound not to be stored")
+	}
+}
+
+func TestCacheStaleWhileRevalidateTemplatedUrl(t *testing.T) {
+	var hits int32
+	u := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		w.Write([]byte(fmt.Sprintf(`{"path":"%s","n":%d}`, r.URL.Path, n)))
+	}))
+	defer u.Close()
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/items/{id}").Method(http.MethodGet).Route(&RouteRequest{Url: u.URL + "/items/{id}", HttpMethod: http.MethodGet, Cache: NewCache(CacheConfig{})}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	for _, expected := range []string{`{"path":"/items/7","n":1}`, `{"path":"/items/7","n":1}`} {
+		res, err := http.Get(s.URL + "/items/7")
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		if err := validateResponse(res, http.StatusOK, expected); err != nil {
+			t.Error(err)
+		}
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&hits) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	res, err := http.Get(s.URL + "/items/7")
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	if err := validateResponse(res, http.StatusOK, `{"path":"/items/7","n":2}`); err != nil {
+		t.Errorf("expected the revalidated response of /items/7: %v", err)
+	}
+}
+
+func TestCacheVaryEvictedWithResponses(t *testing.T) {
+	store := NewLRUStore(1024)
+	s, closeAll := newCacheTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(`{}`))
+	}, NewCache(CacheConfig{Store: store}))
+	defer closeAll()
+
+	for page := 0; page < 50; page++ {
+		for i, lang := range []string{"en", "ko", "en"} {
+			r, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/cached?page=%d", s.URL, page), nil)
+			r.Header.Set("Accept-Language", lang)
+			res, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatalf("error doing request: %v", err)
+			}
+			res.Body.Close()
+			expected := []string{cacheMiss, cacheMiss, cacheHit}[i]
+			if status := res.Header.Get("X-Cache"); status != expected {
+				t.Errorf("page %d, %s: expected X-Cache %s, got %s", page, lang, expected, status)
+			}
+		}
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.size > 1024 {
+		t.Errorf("expected store of at most 1024 bytes, got %d", store.size)
+	}
+}
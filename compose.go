@@ -0,0 +1,389 @@
+package gag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gorillaMux "github.com/gorilla/mux"
+)
+
+// Composition contains all properties about how a request will be composed
+// from the responses of multiple upstream calls.
+// Configure Composition using Condition.Compose() method.
+type Composition struct {
+	// Calls are the upstream calls which will be made for each request.
+	// Calls without dependencies are made in parallel.
+	Calls []*UpstreamCall
+	// Merge merges the results of Calls into a single response body.
+	// results contains the decoded JSON response of each succeeded call, keyed by UpstreamCall.Name.
+	// If Merge is set, Fields are ignored.
+	Merge MergeFunc
+	// Fields declares how the results of Calls are mapped into the response body.
+	// If neither Merge nor Fields is set, the response body is a JSON object
+	// containing each call's result keyed by UpstreamCall.Name.
+	Fields []FieldMapping
+	// Timeout is the timeout value of the whole composition.
+	// If 0, the composition is bound only by the timeout of each call.
+	Timeout time.Duration
+}
+
+// UpstreamCall contains all properties about a single upstream call of a Composition.
+type UpstreamCall struct {
+	// Name identifies the call. It must be unique within a Composition.
+	Name string
+	// Url is the url that the request will be sent to.
+	// Url may contain placeholders, which are replaced before the request is sent:
+	//  {id}           is replaced with the path variable "id".
+	//  {user.team.id} is replaced with the field "team.id" of the result of the call named "user".
+	Url string
+	// HttpMethod is the HTTP method that will be used to send the request.
+	HttpMethod string
+	// Timeout is the timeout value of the request, which will be sent to the Url.
+	Timeout time.Duration
+	// PassRequestBody determines whether the request body will be sent to the Url.
+	PassRequestBody bool
+	// Optional determines whether the composition can succeed when this call fails.
+	// Results of failed optional calls are omitted.
+	Optional bool
+	// DependsOn contains the names of the calls which should succeed before this call is made.
+	DependsOn []string
+}
+
+// MergeFunc merges the results of a Composition into a single response body.
+type MergeFunc func(results map[string]interface{}) (interface{}, error)
+
+// FieldMapping maps a field of an UpstreamCall's result into the response body.
+type FieldMapping struct {
+	// From is the dot separated path of the source field, starting with the call's name.
+	// For example, "user.profile.name" or "user" to map the whole result.
+	From string
+	// To is the dot separated path of the field in the response body.
+	To string
+}
+
+// callResult is the outcome of a single UpstreamCall.
+type callResult struct {
+	value interface{}
+	err   error
+	done  chan struct{}
+}
+
+// errCompositionFailed is returned when a required call of a Composition fails.
+type errCompositionFailed struct {
+	call string
+	err  error
+}
+
+func (e *errCompositionFailed) Error() string {
+	return fmt.Sprintf("upstream(%s) failed: %s", e.call, e.err.Error())
+}
+
+func (cp *Composition) validate() error {
+	names := map[string]bool{}
+	for _, call := range cp.Calls {
+		if call.Name == "" {
+			return errors.New("composition call name cannot be \"\"")
+		}
+		if names[call.Name] {
+			return fmt.Errorf("composition call name(%s) is duplicated", call.Name)
+		}
+		names[call.Name] = true
+	}
+	for _, call := range cp.Calls {
+		for _, dep := range call.DependsOn {
+			if !names[dep] {
+				return fmt.Errorf("composition call(%s) depends on unknown call(%s)", call.Name, dep)
+			}
+		}
+	}
+	if name, ok := cp.findCycle(); ok {
+		return fmt.Errorf("composition call(%s) has a circular dependency", name)
+	}
+	return nil
+}
+
+func (cp *Composition) findCycle() (string, bool) {
+	deps := map[string][]string{}
+	for _, call := range cp.Calls {
+		deps[call.Name] = call.DependsOn
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		switch state[name] {
+		case visiting:
+			return true
+		case visited:
+			return false
+		}
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if visit(dep) {
+				return true
+			}
+		}
+		state[name] = visited
+		return false
+	}
+	for _, call := range cp.Calls {
+		if visit(call.Name) {
+			return call.Name, true
+		}
+	}
+	return "", false
+}
+
+func (cp *Composition) handlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if cp.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cp.Timeout)
+			defer cancel()
+		}
+
+		var reqBody []byte
+		if r.Body != nil {
+			defer r.Body.Close()
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				respondUpstreamError(w, r, err)
+				return
+			}
+			reqBody = b
+		}
+
+		results, err := cp.execute(ctx, gorillaMux.Vars(r), reqBody)
+		if err != nil {
+			respondUpstreamError(w, r, err)
+			return
+		}
+
+		var body interface{}
+		switch {
+		case cp.Merge != nil:
+			body, err = cp.Merge(results)
+			if err != nil {
+				respond500(w, r, err)
+				return
+			}
+		case len(cp.Fields) > 0:
+			body = mapFields(cp.Fields, results)
+		default:
+			body = results
+		}
+
+		res, err := json.Marshal(body)
+		if err != nil {
+			respond500(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(res)
+	}
+}
+
+// execute makes all calls of the composition, respecting their dependencies,
+// and returns the results of the succeeded calls.
+func (cp *Composition) execute(ctx context.Context, vars map[string]string, reqBody []byte) (map[string]interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(map[string]*callResult, len(cp.Calls))
+	for _, call := range cp.Calls {
+		results[call.Name] = &callResult{done: make(chan struct{})}
+	}
+
+	var wg sync.WaitGroup
+	for _, call := range cp.Calls {
+		wg.Add(1)
+		go func(call *UpstreamCall) {
+			defer wg.Done()
+			result := results[call.Name]
+			defer close(result.done)
+
+			deps := map[string]interface{}{}
+			for _, name := range call.DependsOn {
+				dep := results[name]
+				select {
+				case <-dep.done:
+				case <-ctx.Done():
+					result.err = ctx.Err()
+					return
+				}
+				if dep.err != nil {
+					result.err = fmt.Errorf("dependency(%s) failed", name)
+					return
+				}
+				deps[name] = dep.value
+			}
+
+			result.value, result.err = call.do(ctx, vars, deps, reqBody)
+			if result.err != nil && !call.Optional {
+				cancel()
+			}
+		}(call)
+	}
+	wg.Wait()
+
+	merged := map[string]interface{}{}
+	for _, call := range cp.Calls {
+		result := results[call.Name]
+		if result.err != nil {
+			if call.Optional {
+				continue
+			}
+			return nil, &errCompositionFailed{call: call.Name, err: result.err}
+		}
+		merged[call.Name] = result.value
+	}
+	return merged, nil
+}
+
+func (call *UpstreamCall) do(ctx context.Context, vars map[string]string, deps map[string]interface{}, reqBody []byte) (interface{}, error) {
+	url, err := expandUrl(call.Url, vars, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	if call.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, call.Timeout)
+		defer cancel()
+	}
+
+	var body io.Reader
+	if call.PassRequestBody {
+		body = bytes.NewReader(reqBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, call.HttpMethod, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if call.PassRequestBody {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if len(bytes.TrimSpace(bodyBytes)) == 0 {
+		return nil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(bodyBytes, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// expandUrl replaces placeholders of rawUrl with path variables or fields of dependency results.
+// The values are escaped, so that they can't change the path or the query of rawUrl beyond the placeholders.
+func expandUrl(rawUrl string, vars map[string]string, deps map[string]interface{}) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(rawUrl, "{")
+		if start < 0 {
+			b.WriteString(rawUrl)
+			return b.String(), nil
+		}
+		end := strings.Index(rawUrl[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unclosed placeholder in url(%s)", rawUrl)
+		}
+		end += start
+
+		b.WriteString(rawUrl[:start])
+		key := rawUrl[start+1 : end]
+		var value string
+		if v, ok := vars[key]; ok {
+			value = v
+		} else if v, ok := lookupField(deps, strings.Split(key, ".")); ok {
+			formatted, err := formatPlaceholder(v)
+			if err != nil {
+				return "", fmt.Errorf("placeholder(%s) %s", key, err.Error())
+			}
+			value = formatted
+		} else {
+			return "", fmt.Errorf("placeholder(%s) cannot be resolved", key)
+		}
+		if strings.Contains(b.String(), "?") {
+			b.WriteString(url.QueryEscape(value))
+		} else {
+			b.WriteString(url.PathEscape(value))
+		}
+		rawUrl = rawUrl[end+1:]
+	}
+}
+
+// formatPlaceholder formats v, a field of a dependency result decoded by encoding/json, as the value of a placeholder.
+// Numbers are formatted without exponents, so that an id such as 1234567 is kept as it is.
+func formatPlaceholder(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	}
+	return "", errors.New("must be a string, number or boolean")
+}
+
+// lookupField returns the value found by following path from v.
+func lookupField(v interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		var m map[string]interface{}
+		switch t := v.(type) {
+		case map[string]interface{}:
+			m = t
+		default:
+			return nil, false
+		}
+		next, ok := m[key]
+		if !ok {
+			return nil, false
+		}
+		v = next
+	}
+	return v, true
+}
+
+// mapFields builds a response body from results, according to fields.
+// Fields which cannot be found in results are omitted.
+func mapFields(fields []FieldMapping, results map[string]interface{}) map[string]interface{} {
+	body := map[string]interface{}{}
+	for _, f := range fields {
+		v, ok := lookupField(results, strings.Split(f.From, "."))
+		if !ok {
+			continue
+		}
+		setField(body, strings.Split(f.To, "."), v)
+	}
+	return body
+}
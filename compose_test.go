@@ -0,0 +1,164 @@
+package gag
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func jsonHandler(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}
+}
+
+func newComposeUpstream() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/1", jsonHandler(`{"name":"sang","teamId":7}`))
+	mux.HandleFunc("/teams/7", jsonHandler(`{"name":"gateway"}`))
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		jsonHandler(`{}`)(w, r)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestComposeFieldMappingWithDependency(t *testing.T) {
+	upstream := newComposeUpstream()
+	defer upstream.Close()
+
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/users/{id}").Method(http.MethodGet).Compose(&Composition{
+		Calls: []*UpstreamCall{
+			{Name: "user", Url: upstream.URL + "/users/{id}", HttpMethod: http.MethodGet},
+			{Name: "team", Url: upstream.URL + "/teams/{user.teamId}", HttpMethod: http.MethodGet, DependsOn: []string{"user"}},
+			{Name: "extra", Url: upstream.URL + "/broken", HttpMethod: http.MethodGet, Optional: true},
+		},
+		Fields: []FieldMapping{
+			{From: "user.name", To: "name"},
+			{From: "team.name", To: "team.name"},
+			{From: "extra", To: "extra"},
+		},
+	}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/users/1")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+
+	if err := validateResponse(res, http.StatusOK, `{"name":"sang","team":{"name":"gateway"}}`); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestComposeMergeFunc(t *testing.T) {
+	upstream := newComposeUpstream()
+	defer upstream.Close()
+
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/merge").Compose(&Composition{
+		Calls: []*UpstreamCall{
+			{Name: "user", Url: upstream.URL + "/users/1", HttpMethod: http.MethodGet},
+			{Name: "team", Url: upstream.URL + "/teams/7", HttpMethod: http.MethodGet},
+		},
+		Merge: func(results map[string]interface{}) (interface{}, error) {
+			user := results["user"].(map[string]interface{})
+			team := results["team"].(map[string]interface{})
+			return fmt.Sprintf("%s@%s", user["name"], team["name"]), nil
+		},
+	}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/merge")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+
+	if err := validateResponse(res, http.StatusOK, `"sang@gateway"`); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestComposeRequiredCallFailureResponse502(t *testing.T) {
+	upstream := newComposeUpstream()
+	defer upstream.Close()
+
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/fail").Compose(&Composition{
+		Calls: []*UpstreamCall{
+			{Name: "user", Url: upstream.URL + "/users/1", HttpMethod: http.MethodGet},
+			{Name: "slow", Url: upstream.URL + "/slow", HttpMethod: http.MethodGet, Timeout: 50 * time.Millisecond},
+		},
+	}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/fail")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+
+	if res.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected status code %d, got %d", http.StatusBadGateway, res.StatusCode)
+		return
+	}
+}
+
+func TestComposeValidation(t *testing.T) {
+	cp := &Composition{
+		Calls: []*UpstreamCall{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"a"}},
+		},
+	}
+	if err := cp.validate(); err == nil {
+		t.Error(errors.New("expected circular dependency error"))
+		return
+	}
+}
+
+func TestExpandUrl(t *testing.T) {
+	vars := map[string]string{"id": "a/b?c"}
+	deps := map[string]interface{}{
+		"user": map[string]interface{}{"id": float64(1234567), "name": "sang&admin=true", "team": map[string]interface{}{}},
+	}
+	tests := []struct {
+		url      string
+		expected string
+		err      bool
+	}{
+		{"http://127.0.0.1/users/{user.id}", "http://127.0.0.1/users/1234567", false},
+		{"http://127.0.0.1/files/{id}", "http://127.0.0.1/files/a%2Fb%3Fc", false},
+		{"http://127.0.0.1/search?name={user.name}&id={id}", "http://127.0.0.1/search?name=sang%26admin%3Dtrue&id=a%2Fb%3Fc", false},
+		{"http://127.0.0.1/teams/{user.team}", "", true},
+		{"http://127.0.0.1/teams/{team.id}", "", true},
+	}
+	for _, tt := range tests {
+		expanded, err := expandUrl(tt.url, vars, deps)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: unexpected error %v", tt.url, err)
+		}
+		if expanded != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.url, tt.expected, expanded)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package gag
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressionMinSize is the minimum response size to be compressed when CompressionConfig.MinSize is 0.
+const DefaultCompressionMinSize = 1024
+
+// DefaultCompressionContentTypes are the content types to be compressed when CompressionConfig.ContentTypes is empty.
+var DefaultCompressionContentTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/problem+json",
+	"image/svg+xml",
+	"text/*",
+}
+
+// CompressionConfig contains properties for the Compress middleware.
+type CompressionConfig struct {
+	// MinSize is the minimum response body size in bytes to be compressed.
+	// When 0, DefaultCompressionMinSize will be used.
+	MinSize int
+	// ContentTypes are the content types of responses to be compressed.
+	// A content type ending with "/*" matches all subtypes.
+	// When empty, DefaultCompressionContentTypes will be used.
+	ContentTypes []string
+	// Level is the compression level, from flate.BestSpeed to flate.BestCompression.
+	// When 0, flate.DefaultCompression will be used.
+	Level int
+}
+
+// Supported content encodings, in order of preference.
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// Compress returns a Middleware which compresses response bodies with gzip or deflate,
+// negotiated by the Accept-Encoding request header.
+// Responses which already have a Content-Encoding, such as compressed responses relayed from an upstream,
+// are written as they are.
+// Example:
+//  g.Conditions().Path("/foo").Middlewares(gag.Compress(gag.CompressionConfig{MinSize: 512})).Route(...)
+func Compress(cfg CompressionConfig) Middleware {
+	if cfg.MinSize == 0 {
+		cfg.MinSize = DefaultCompressionMinSize
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultCompressionContentTypes
+	}
+	if cfg.Level == 0 {
+		cfg.Level = flate.DefaultCompression
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				h.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, cfg: cfg, encoding: encoding}
+			defer cw.close()
+			h.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding returns the preferred supported encoding listed in acceptEncoding,
+// or "" if none is acceptable. As in RFC 9110 section 12.5.3, "*" matches the encodings not listed explicitly,
+// and an encoding of q=0, such as "gzip;q=0", is not acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		name, q := strings.ToLower(strings.TrimSpace(params[0])), 1.0
+		if name == "" {
+			continue
+		}
+		valid := true
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(strings.ToLower(param), "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					valid = false
+					break
+				}
+				q = v
+			}
+		}
+		if valid {
+			qualities[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range []string{encodingGzip, encodingDeflate} {
+		q, ok := qualities[name]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the response until it can decide whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	cfg        CompressionConfig
+	encoding   string
+	statusCode int
+	buf        []byte
+	decided    bool
+	cw         io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(statusCode int) {
+	if w.statusCode != 0 {
+		return
+	}
+	w.statusCode = statusCode
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	if w.decided {
+		if w.cw != nil {
+			return w.cw.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.cfg.MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide starts writing the response, compressed if compressible is true and the response is eligible.
+func (w *compressWriter) decide(compressible bool) error {
+	w.decided = true
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	header := w.Header()
+	if compressible && w.eligible() {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		switch w.encoding {
+		case encodingGzip:
+			w.cw, _ = gzip.NewWriterLevel(w.ResponseWriter, w.cfg.Level)
+		case encodingDeflate:
+			w.cw, _ = flate.NewWriter(w.ResponseWriter, w.cfg.Level)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.statusCode)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.cw != nil {
+		_, err = w.cw.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (w *compressWriter) eligible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if w.statusCode < 200 || w.statusCode == http.StatusNoContent || w.statusCode == http.StatusNotModified {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(w.buf)
+	}
+	return matchContentType(w.cfg.ContentTypes, contentType)
+}
+
+// Flush writes the buffered response, compressing it if eligible regardless of its size.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) > 0)
+	}
+	if f, ok := w.cw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets upgraded connections bypass compression.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not supported")
+	}
+	w.decided = true
+	return hj.Hijack()
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.statusCode == 0 {
+			// Nothing was written by the handler.
+			return
+		}
+		w.decide(false)
+	}
+	if w.cw != nil {
+		w.cw.Close()
+	}
+}
+
+// matchContentType reports whether contentType matches one of contentTypes.
+func matchContentType(contentTypes []string, contentType string) bool {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for _, ct := range contentTypes {
+		if strings.HasSuffix(ct, "/*") {
+			if strings.HasPrefix(contentType, strings.TrimSuffix(ct, "*")) {
+				return true
+			}
+		} else if ct == contentType {
+			return true
+		}
+	}
+	return false
+}
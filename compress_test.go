@@ -0,0 +1,159 @@
+package gag
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func sizedHandler(contentType string, size int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(strings.Repeat("a", size)))
+	}
+}
+
+func doCompressRequest(t *testing.T, h http.Handler, acceptEncoding string) *http.Response {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func TestCompressGzip(t *testing.T) {
+	h := Compress(CompressionConfig{})(sizedHandler("application/json", 2048))
+	res := doCompressRequest(t, h, "deflate;q=0.5, gzip")
+
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected content encoding %s, got %s", "gzip", res.Header.Get("Content-Encoding"))
+		return
+	}
+	gr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Errorf("error creating gzip reader: %v", err)
+		return
+	}
+	body, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Errorf("error reading response body: %v", err)
+		return
+	}
+	if len(body) != 2048 {
+		t.Errorf("expected decompressed size %d, got %d", 2048, len(body))
+	}
+}
+
+func TestCompressDeflate(t *testing.T) {
+	h := Compress(CompressionConfig{})(sizedHandler("text/plain; charset=utf-8", 2048))
+	res := doCompressRequest(t, h, "gzip;q=0.2, deflate")
+
+	if res.Header.Get("Content-Encoding") != "deflate" {
+		t.Errorf("expected content encoding %s, got %s", "deflate", res.Header.Get("Content-Encoding"))
+		return
+	}
+	body, err := ioutil.ReadAll(flate.NewReader(res.Body))
+	if err != nil {
+		t.Errorf("error reading response body: %v", err)
+		return
+	}
+	if len(body) != 2048 {
+		t.Errorf("expected decompressed size %d, got %d", 2048, len(body))
+	}
+}
+
+func TestCompressSkipsIneligibleResponses(t *testing.T) {
+	tests := []struct {
+		name           string
+		h              http.Handler
+		acceptEncoding string
+	}{
+		{"below min size", sizedHandler("application/json", 100), "gzip"},
+		{"content type not allowed", sizedHandler("image/png", 2048), "gzip"},
+		{"encoding not accepted", sizedHandler("application/json", 2048), "br, gzip;q=0"},
+	}
+	for _, tt := range tests {
+		res := doCompressRequest(t, Compress(CompressionConfig{})(tt.h), tt.acceptEncoding)
+		if res.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: expected no content encoding, got %s", tt.name, res.Header.Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestCompressRelaysCompressedUpstreamResponse(t *testing.T) {
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write([]byte(strings.Repeat("a", 2048)))
+	gw.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer upstream.Close()
+
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/compressed").Middlewares(Compress(CompressionConfig{})).Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	r, err := http.NewRequest(http.MethodGet, s.URL+"/compressed", nil)
+	if err != nil {
+		t.Errorf("error creating request: %v", err)
+		return
+	}
+	r.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected content encoding %s, got %s", "gzip", res.Header.Get("Content-Encoding"))
+		return
+	}
+	gr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Errorf("error creating gzip reader: %v", err)
+		return
+	}
+	body, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Errorf("error reading response body: %v", err)
+		return
+	}
+	if len(body) != 2048 {
+		t.Errorf("expected body compressed only once, got decompressed size %d", len(body))
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"gzip, deflate", "gzip"},
+		{"deflate;q=0.5, gzip;q=0.4", "deflate"},
+		{"*", "gzip"},
+		{"*;q=0", ""},
+		{"gzip;q=0, *", "deflate"},
+		{"gzip;q=0, deflate;q=0, *", ""},
+		{"br, *;q=0.1", "gzip"},
+		{"identity", ""},
+		{"gzip;q=abc", ""},
+	}
+	for _, tt := range tests {
+		if encoding := negotiateEncoding(tt.acceptEncoding); encoding != tt.expected {
+			t.Errorf("%s: expected encoding %q, got %q", tt.acceptEncoding, tt.expected, encoding)
+		}
+	}
+}
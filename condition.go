@@ -44,14 +44,57 @@ type Condition struct {
 	// Only one of handlerFunc or routeRequest can be set per Condition.
 	// Configure handlerFunc using Condition.HandlerFunc() method.
 	handlerFunc http.HandlerFunc
+	// composition contains all properties about how the request will be composed from multiple upstream calls.
+	// Only one of composition, routeRequest or handlerFunc can be set per Condition.
+	// Configure composition using Condition.Compose() method.
+	composition *Composition
+	// grpcRoute contains all properties about where and how gRPC requests will be proxied.
+	// Only one of grpcRoute, composition, routeRequest or handlerFunc can be set per Condition.
+	// Configure grpcRoute using Condition.Grpc() method.
+	grpcRoute *GrpcRoute
 	// middlewares is a list of middlewares to be applied to the request.
 	// Configure middlewares using Condition.Middlewares() method.
 	middlewares middlewareChain
+	// phases contains the middlewares of each Phase.
+	// Configure phases using Condition.Use() method.
+	phases phasedMiddlewares
+	// upstreamHooks run at PhasePostUpstream, after the response of routeRequest is received.
+	// Configure upstreamHooks using Condition.OnUpstreamResponse() method.
+	upstreamHooks []UpstreamHook
+	// matchers are the header matchers inherited from Groups, which run along with the Condition's own.
+	matchers []Middleware
+	// ipFilters allow or deny requests by client ip, starting from those of the outermost Group.
+	// Configure ipFilters using Condition.IPFilter() method.
+	ipFilters []*IPFilter
+	// validation validates requests before the PhasePreUpstream middlewares run.
+	// Configure validation using Condition.Validate() method.
+	validation *RequestValidation
+	// maxBodyBytes is the maximum size of the request body.
+	// If not set, the gateway-wide Config.MaxRequestBodyBytes will be used.
+	// Configure maxBodyBytes using Condition.MaxBodyBytes() method.
+	maxBodyBytes int64
+	// cors is the CORS configuration of the request.
+	// If not set, the gateway-wide Config.CORS will be used.
+	// Configure cors using Condition.CORS() method.
+	cors *CORSConfig
+	// id identifies the Condition in the admin API. It is assigned when the router is built.
+	id int
+	// disabled determines whether the Condition is excluded from the router.
+	// Configure disabled using the admin API.
+	disabled bool
+	// pathItem is the OpenAPI path item which the Condition is generated from by Gag.ImportOpenAPI().
+	// Conditions of the same path item and path are dispatched by HTTP method, instead of the first one handling all methods.
+	pathItem *OpenAPIPathItem
+	// group is the Group which the Condition is added through. Conditions following it in the builder chain belong to it too.
+	// Configure group using Group.Conditions() method.
+	group *Group
 }
 
 // RouteRequest contains all properties about where and how the request will be routed.
 type RouteRequest struct {
 	// Url is the url that the request will be routed to.
+	// Url may contain placeholders of the path variables of the Condition, such as {id},
+	// which are replaced with the values of the variables.
 	Url string
 	// HttpMethod is the HTTP method that will be used to route the request.
 	HttpMethod string
@@ -59,6 +102,37 @@ type RouteRequest struct {
 	Timeout time.Duration
 	// PassRequestBody determines whether the request body will be sent to the Url.
 	PassRequestBody bool
+	// RequestTransform rewrites the request body before it is sent to the Url.
+	// It requires PassRequestBody. If nil, the request body is sent as it is.
+	RequestTransform *RequestTransform
+	// ResponseTransform rewrites the response of the Url before it is written.
+	// If nil, the response is written as it is.
+	ResponseTransform *ResponseTransform
+	// Mirror duplicates a percentage of the requests routed to the Url to a shadow upstream.
+	// If nil, requests are not duplicated.
+	Mirror *Mirror
+	// Split splits the requests between versions of the upstream, such as a stable version and a canary.
+	// If set, the requests are routed to the Url of the chosen version instead of the Url.
+	Split *TrafficSplit
+	// Hedge sends a second request if the Url hasn't responded within a delay, using the first successful response.
+	// If nil, requests are not hedged.
+	Hedge *Hedge
+	// Discovery discovers the endpoints of the Url, such as from DNS, which the requests are routed to in turn.
+	// If nil, the requests are routed to the Url as it is.
+	Discovery *Discovery
+	// Cache caches responses of GET requests routed to the Url.
+	// If nil, every request is routed to the Url.
+	Cache *Cache
+	// UpgradeIdleTimeout is how long an upgraded connection, such as a WebSocket, is kept open
+	// while no data is transferred. When 0, upgraded connections are never closed for being idle.
+	UpgradeIdleTimeout time.Duration
+	// CircuitBreaker short-circuits requests to the Url with 503 while it is failing.
+	// If nil, every request is routed to the Url.
+	CircuitBreaker *CircuitBreaker
+	// tunnels are the connection-count metrics of upgraded connections.
+	tunnels tunnelCounters
+	// health is the health of the Url, observed from the routed requests.
+	health upstreamHealth
 }
 
 type headerValue struct {
@@ -106,7 +180,27 @@ func (c *Condition) HasHeaderValue(key string, value string) *Condition {
 	return c
 }
 
+// CORS sets Condition's cors property, overriding the gateway-wide Config.CORS.
+// Preflight requests are answered before the HTTP method and headers of the request are checked.
+// Example:
+//  g.Condition().Path("/foo").Method(http.MethodPut).CORS(&gag.CORSConfig{AllowedOrigins: []string{"https://*.example.com"}}).Route(...)
+func (c *Condition) CORS(cors *CORSConfig) *Condition {
+	c.cors = cors
+	return c
+}
+
+// MaxBodyBytes sets Condition's maxBodyBytes property, overriding the gateway-wide Config.MaxRequestBodyBytes.
+// Requests having a body larger than maxBodyBytes are responded with 413.
+// Example:
+//  g.Condition().Path("/upload").Method(http.MethodPost).MaxBodyBytes(10 << 20).Route(...)
+func (c *Condition) MaxBodyBytes(maxBodyBytes int64) *Condition {
+	c.maxBodyBytes = maxBodyBytes
+	return c
+}
+
 // Middlewares sets Condition's middlewares property.
+// The last middleware runs first, wrapping the others. They run between PhaseAuth and PhasePreUpstream,
+// so use Condition.Use() for middlewares running in the order given at an explicit Phase.
 // Example:
 //  func sampleTimingMiddleware() func(h http.Handler) http.Handler {
 //	  return func(h http.Handler) http.Handler {
@@ -137,8 +231,7 @@ func (c *Condition) Middlewares(middlewares ...Middleware) *Condition {
 //	  }, g)
 func (c *Condition) Route(routeRequest *RouteRequest, g *Gag) *Condition {
 	c.routeRequest = routeRequest
-	g.conditions = append(g.conditions, c)
-	return &Condition{}
+	return c.add(g)
 }
 
 // HandlerFunc sets Condition's handlerFunc property.
@@ -152,8 +245,50 @@ func (c *Condition) Route(routeRequest *RouteRequest, g *Gag) *Condition {
 //  g.Condition().Path("/foo").HandlerFunc(sampleHandler())
 func (c *Condition) HandlerFunc(handlerFunc http.HandlerFunc, g *Gag) *Condition {
 	c.handlerFunc = handlerFunc
+	return c.add(g)
+}
+
+// Compose sets Condition's composition property.
+// Only one of composition, routeRequest or handlerFunc can be set per Condition.
+// Example:
+//  g.Conditions().
+//	  Path("/users/{id}").Method(http.MethodGet).Compose(&gag.Composition{
+//		  Calls: []*gag.UpstreamCall{
+//			  {Name: "user", Url: "http://127.0.0.1:8081/users/{id}", HttpMethod: http.MethodGet},
+//			  {Name: "team", Url: "http://127.0.0.1:8082/teams/{user.teamId}", HttpMethod: http.MethodGet, DependsOn: []string{"user"}},
+//			  {Name: "orders", Url: "http://127.0.0.1:8083/orders?user={id}", HttpMethod: http.MethodGet, Optional: true},
+//		  },
+//		  Fields: []gag.FieldMapping{
+//			  {From: "user.name", To: "name"},
+//			  {From: "team.name", To: "team"},
+//			  {From: "orders", To: "orders"},
+//		  },
+//	  }, g)
+func (c *Condition) Compose(composition *Composition, g *Gag) *Condition {
+	c.composition = composition
+	return c.add(g)
+}
+
+// Grpc sets Condition's grpcRoute property.
+// Native gRPC requests need HTTP/2, while gRPC-Web requests from browsers are served over HTTP/1.1 as well.
+// Example:
+//  g.Conditions().
+//	  Path("/helloworld.Greeter/{method}").Method(http.MethodPost).Grpc(&gag.GrpcRoute{
+//		  Url:     "http://127.0.0.1:50051",
+//		  GrpcWeb: true,
+//	  }, g)
+func (c *Condition) Grpc(grpcRoute *GrpcRoute, g *Gag) *Condition {
+	c.grpcRoute = grpcRoute
+	return c.add(g)
+}
+
+// add applies the properties of c's Group to c, adds c to g and returns the next Condition of the builder chain.
+func (c *Condition) add(g *Gag) *Condition {
+	if c.group != nil {
+		c.group.apply(c)
+	}
 	g.conditions = append(g.conditions, c)
-	return &Condition{}
+	return &Condition{group: c.group}
 }
 
 func (mc middlewareChain) wrap(handlerFunc http.HandlerFunc, h http.Handler) http.Handler {
@@ -0,0 +1,169 @@
+package gag
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSMethods are the methods allowed when CORSConfig.AllowedMethods is empty
+// and the Condition doesn't restrict its method.
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// CORSConfig contains properties about Cross-Origin Resource Sharing.
+// Set CORSConfig gateway-wide with Config.CORS, or per Condition using Condition.CORS() method.
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to make cross-origin requests.
+	// "*" allows all origins, and an origin can have a single wildcard such as "https://*.example.com".
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed in cross-origin requests.
+	// When empty, the method of the Condition is allowed, or GET, HEAD and POST if the Condition has none.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in cross-origin requests.
+	// When empty, the headers requested in preflight requests are allowed.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers which browsers are allowed to expose to scripts.
+	ExposedHeaders []string
+	// AllowCredentials determines whether cross-origin requests can include credentials such as cookies.
+	// It cannot be set along with "*" in AllowedOrigins, which would let any site read credentialed responses.
+	AllowCredentials bool
+	// MaxAge is how long the results of preflight requests can be cached by browsers.
+	// When 0, Access-Control-Max-Age header is not written.
+	MaxAge time.Duration
+}
+
+func (cc *CORSConfig) validate() error {
+	if cc.AllowCredentials && hasToken(cc.AllowedOrigins, "*") {
+		return errors.New("CORS cannot allow credentials along with any origin(*)")
+	}
+	return nil
+}
+
+// isPreflight reports whether r is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// handlePreflight answers the preflight request r for a Condition allowing httpMethod.
+func (cc *CORSConfig) handlePreflight(w http.ResponseWriter, r *http.Request, httpMethod string) {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	if !cc.allowsOrigin(origin) {
+		respond403(w, r, fmt.Sprintf("origin(%s) not allowed", origin))
+		return
+	}
+	method := r.Header.Get("Access-Control-Request-Method")
+	methods := cc.methods(httpMethod)
+	if !hasToken(methods, method) {
+		respond403(w, r, fmt.Sprintf("method(%s) not allowed", method))
+		return
+	}
+	requested := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+	allowedHeaders := requested
+	if len(cc.AllowedHeaders) > 0 && !hasToken(cc.AllowedHeaders, "*") {
+		for _, h := range requested {
+			if !cc.allowsHeader(h) {
+				respond403(w, r, fmt.Sprintf("header(%s) not allowed", h))
+				return
+			}
+		}
+		allowedHeaders = cc.AllowedHeaders
+	}
+
+	cc.writeOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(allowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+	}
+	if cc.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(cc.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeHeaders writes CORS response headers for the actual (non-preflight) request r.
+func (cc *CORSConfig) writeHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	if !cc.allowsOrigin(origin) {
+		return
+	}
+	cc.writeOrigin(header, origin)
+	if len(cc.ExposedHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(cc.ExposedHeaders, ", "))
+	}
+}
+
+// writeOrigin writes the allowed origin for origin, along with whether credentials are allowed.
+func (cc *CORSConfig) writeOrigin(header http.Header, origin string) {
+	if cc.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(cc.AllowedOrigins) == 1 && cc.AllowedOrigins[0] == "*" {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+}
+
+func (cc *CORSConfig) allowsOrigin(origin string) bool {
+	for _, pattern := range cc.AllowedOrigins {
+		if matchWildcard(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cc *CORSConfig) allowsHeader(header string) bool {
+	for _, h := range cc.AllowedHeaders {
+		if strings.EqualFold(h, header) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cc *CORSConfig) methods(httpMethod string) []string {
+	if len(cc.AllowedMethods) > 0 {
+		return cc.AllowedMethods
+	}
+	if httpMethod != "" {
+		return []string{httpMethod}
+	}
+	return defaultCORSMethods
+}
+
+// matchWildcard reports whether s matches pattern, which can contain a single "*" matching any string.
+func matchWildcard(pattern string, s string) bool {
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return strings.EqualFold(pattern, s)
+	}
+	prefix, suffix := strings.ToLower(pattern[:i]), strings.ToLower(pattern[i+1:])
+	s = strings.ToLower(s)
+	return len(s) >= len(prefix)+len(suffix) && strings.HasPrefix(s, prefix) && strings.HasSuffix(s, suffix)
+}
+
+func parseHeaderList(value string) []string {
+	var headers []string
+	for _, h := range strings.Split(value, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	return headers
+}
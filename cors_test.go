@@ -0,0 +1,168 @@
+package gag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCORSTestServer() *httptest.Server {
+	g := NewGag(Config{CORS: &CORSConfig{AllowedOrigins: []string{"*"}}})
+	g.Conditions().
+		Path("/global").Method(http.MethodPut).HandlerFunc(sampleHandler(), g).
+		Path("/cors").Method(http.MethodPost).CORS(&CORSConfig{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedHeaders:   []string{"X-Key"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}).HandlerFunc(sampleHandler(), g)
+	return newTestServer(g)
+}
+
+func doPreflight(t *testing.T, url string, origin string, method string, headers string) *http.Response {
+	r, err := http.NewRequest(http.MethodOptions, url, nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestCORSPreflightBeforeMethodMatching(t *testing.T) {
+	s := newCORSTestServer()
+	defer s.Close()
+
+	res := doPreflight(t, s.URL+"/global", "https://any.origin", http.MethodPut, "")
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status code %d, got %d", http.StatusNoContent, res.StatusCode)
+		return
+	}
+	if res.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected allowed origin %s, got %s", "*", res.Header.Get("Access-Control-Allow-Origin"))
+	}
+	if res.Header.Get("Access-Control-Allow-Methods") != http.MethodPut {
+		t.Errorf("expected allowed methods %s, got %s", http.MethodPut, res.Header.Get("Access-Control-Allow-Methods"))
+	}
+}
+
+func TestCORSConditionOverridesGlobal(t *testing.T) {
+	s := newCORSTestServer()
+	defer s.Close()
+
+	res := doPreflight(t, s.URL+"/cors", "https://api.example.com", http.MethodPost, "x-key")
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status code %d, got %d", http.StatusNoContent, res.StatusCode)
+		return
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://api.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Headers":     "X-Key",
+		"Access-Control-Max-Age":           "600",
+	}
+	for k, v := range expected {
+		if res.Header.Get(k) != v {
+			t.Errorf("expected %s %s, got %s", k, v, res.Header.Get(k))
+		}
+	}
+
+	for _, tt := range []struct {
+		origin  string
+		method  string
+		headers string
+	}{
+		{"https://evil.com", http.MethodPost, ""},
+		{"https://api.example.com", http.MethodDelete, ""},
+		{"https://api.example.com", http.MethodPost, "X-Other"},
+	} {
+		res := doPreflight(t, s.URL+"/cors", tt.origin, tt.method, tt.headers)
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("%v: expected status code %d, got %d", tt, http.StatusForbidden, res.StatusCode)
+		}
+	}
+}
+
+func TestCORSActualRequestHeaders(t *testing.T) {
+	s := newCORSTestServer()
+	defer s.Close()
+
+	r, err := http.NewRequest(http.MethodPost, s.URL+"/cors", nil)
+	if err != nil {
+		t.Errorf("error creating request: %v", err)
+		return
+	}
+	r.Header.Set("Origin", "https://api.example.com")
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+
+	if err := validateResponse(res, http.StatusOK, `{"message":"sample handler!"}`); err != nil {
+		t.Error(err)
+		return
+	}
+	if res.Header.Get("Access-Control-Allow-Origin") != "https://api.example.com" {
+		t.Errorf("expected allowed origin %s, got %s", "https://api.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	}
+	if res.Header.Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Errorf("expected exposed headers %s, got %s", "X-Request-Id", res.Header.Get("Access-Control-Expose-Headers"))
+	}
+}
+
+func TestCORSCredentials(t *testing.T) {
+	g := NewGag(Config{CORS: &CORSConfig{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}})
+	g.Conditions().Path("/credentials").Method(http.MethodPost).HandlerFunc(sampleHandler(), g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	preflight := doPreflight(t, s.URL+"/credentials", "https://app.example.com", http.MethodPost, "")
+	r, err := http.NewRequest(http.MethodPost, s.URL+"/credentials", nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	r.Header.Set("Origin", "https://app.example.com")
+	actual, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	actual.Body.Close()
+
+	for _, res := range []*http.Response{preflight, actual} {
+		if origin := res.Header.Get("Access-Control-Allow-Origin"); origin != "https://app.example.com" {
+			t.Errorf("%s: expected allowed origin %s, got %s", res.Request.Method, "https://app.example.com", origin)
+		}
+		if res.Header.Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%s: expected credentials to be allowed", res.Request.Method)
+		}
+		if !hasToken(res.Header.Values("Vary"), "Origin") {
+			t.Errorf("%s: expected Vary Origin, got %v", res.Request.Method, res.Header.Values("Vary"))
+		}
+	}
+}
+
+func TestCORSRejectsCredentialsWithAnyOrigin(t *testing.T) {
+	anyOrigin := &CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+	global := NewGag(Config{CORS: anyOrigin})
+	global.Conditions().Path("/a").HandlerFunc(sampleHandler(), global)
+	condition := NewGag(Config{})
+	condition.Conditions().Path("/a").CORS(anyOrigin).HandlerFunc(sampleHandler(), condition)
+
+	for _, g := range []*Gag{global, condition} {
+		if _, err := g.Handler(); err == nil || !strings.Contains(err.Error(), "CORS cannot allow credentials along with any origin(*)") {
+			t.Errorf("expected credentials with any origin to be rejected, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package gag
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDiscoveryInterval is the interval of re-resolving endpoints when Discovery.Interval is 0.
+const DefaultDiscoveryInterval = 30 * time.Second
+
+// DefaultDiscoveryTimeout is the timeout of resolving endpoints when Discovery.Timeout is 0.
+const DefaultDiscoveryTimeout = 5 * time.Second
+
+// DiscoveryProvider resolves the endpoints of an upstream, such as from DNS or a service registry.
+// Implement DiscoveryProvider to plug in other registries.
+type DiscoveryProvider interface {
+	// Endpoints returns the current endpoints of the upstream, each formatted as "host:port".
+	Endpoints(ctx context.Context) ([]string, error)
+}
+
+// Discovery contains all properties about how the endpoints of RouteRequest.Url are discovered.
+// Requests are routed to the discovered endpoints in turn, replacing the host of the Url,
+// so that backends scaled up or down are picked up without changing the Url.
+// Endpoints are resolved on the first request, and re-resolved in the background once Interval has passed.
+// When resolving fails, the endpoints resolved last are kept, and while there are none, requests are routed to the Url as it is.
+// Discovery cannot be set along with RouteRequest.Split, and requires an http or ws Url,
+// since the certificates of TLS upstreams are issued for their hostnames rather than the discovered endpoints.
+// Configure Discovery by setting RouteRequest.Discovery.
+type Discovery struct {
+	// Provider resolves the endpoints, such as DNSProvider, FileProvider or HTTPProvider.
+	Provider DiscoveryProvider
+	// Interval is how often the endpoints are re-resolved. When 0, DefaultDiscoveryInterval will be used.
+	Interval time.Duration
+	// Timeout is the timeout of resolving the endpoints. When 0, DefaultDiscoveryTimeout will be used.
+	Timeout time.Duration
+
+	mu         sync.RWMutex
+	endpoints  []string
+	resolvedAt time.Time
+	// resolving serializes the first resolution, which requests wait for.
+	resolving  sync.Mutex
+	refreshing atomic.Bool
+	next       atomic.Uint64
+}
+
+// Endpoints returns the endpoints resolved last.
+func (d *Discovery) Endpoints() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.endpoints)
+}
+
+func (d *Discovery) validate() error {
+	if d.Provider == nil {
+		return errors.New("discovery provider cannot be nil")
+	}
+	if d.Interval < 0 {
+		return fmt.Errorf("discovery interval(%v) cannot be negative", d.Interval)
+	}
+	if d.Timeout < 0 {
+		return fmt.Errorf("discovery timeout(%v) cannot be negative", d.Timeout)
+	}
+	return nil
+}
+
+// endpoint returns the next of the discovered endpoints, or "" if there is none.
+func (d *Discovery) endpoint() string {
+	d.mu.RLock()
+	endpoints, resolvedAt := d.endpoints, d.resolvedAt
+	d.mu.RUnlock()
+
+	switch {
+	case resolvedAt.IsZero():
+		d.resolving.Lock()
+		d.mu.RLock()
+		resolved := !d.resolvedAt.IsZero()
+		d.mu.RUnlock()
+		if !resolved {
+			d.refresh()
+		}
+		d.resolving.Unlock()
+		endpoints = d.Endpoints()
+	case time.Since(resolvedAt) >= d.interval() && d.refreshing.CompareAndSwap(false, true):
+		go func() {
+			defer d.refreshing.Store(false)
+			d.refresh()
+		}()
+	}
+	if len(endpoints) == 0 {
+		return ""
+	}
+	return endpoints[(d.next.Add(1)-1)%uint64(len(endpoints))]
+}
+
+// refresh resolves the endpoints by d.Provider.
+func (d *Discovery) refresh() {
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = DefaultDiscoveryTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	endpoints, err := d.Provider.Endpoints(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resolvedAt = time.Now()
+	if err != nil {
+		logger{}.Println(fmt.Sprintf("discovery failed, keeping %d endpoints: %s", len(d.endpoints), err.Error()))
+		return
+	}
+	if len(endpoints) == 0 {
+		logger{}.Println("discovery found no endpoints")
+	}
+	d.endpoints = endpoints
+}
+
+func (d *Discovery) interval() time.Duration {
+	if d.Interval == 0 {
+		return DefaultDiscoveryInterval
+	}
+	return d.Interval
+}
+
+// endpoint returns target with its host replaced by an endpoint discovered by rr.Discovery,
+// or target as it is if rr has no Discovery or no endpoint is discovered.
+func (rr *RouteRequest) endpoint(target string) string {
+	if rr.Discovery == nil {
+		return target
+	}
+	endpoint := rr.Discovery.endpoint()
+	if endpoint == "" {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	u.Host = endpoint
+	return u.String()
+}
+
+// DNSProvider resolves endpoints from the A/AAAA or SRV records of a DNS name.
+type DNSProvider struct {
+	// Name is the DNS name to look up, such as "users.internal" or "_http._tcp.users.internal" for SRV records.
+	Name string
+	// Port is the port of the endpoints resolved from A/AAAA records. It is ignored for SRV records, which have their own ports.
+	Port int
+	// SRV determines whether SRV records are looked up instead of A/AAAA records.
+	SRV bool
+	// Resolver is used to look up the records. When nil, net.DefaultResolver will be used.
+	Resolver *net.Resolver
+}
+
+// Endpoints implements DiscoveryProvider.
+func (p *DNSProvider) Endpoints(ctx context.Context) ([]string, error) {
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	var endpoints []string
+	if p.SRV {
+		_, records, err := resolver.LookupSRV(ctx, "", "", p.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			endpoints = append(endpoints, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+		}
+	} else {
+		addrs, err := resolver.LookupHost(ctx, p.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			endpoints = append(endpoints, net.JoinHostPort(addr, strconv.Itoa(p.Port)))
+		}
+	}
+	slices.Sort(endpoints)
+	return endpoints, nil
+}
+
+// FileProvider resolves endpoints from a file, which is re-read when it is modified.
+// The file has an endpoint formatted as "host:port" per line. Empty lines and lines starting with "#" are ignored.
+type FileProvider struct {
+	// Path is the path of the file.
+	Path string
+
+	mu        sync.Mutex
+	modTime   time.Time
+	size      int64
+	endpoints []string
+}
+
+// Endpoints implements DiscoveryProvider.
+func (p *FileProvider) Endpoints(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	if p.endpoints != nil && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.endpoints, nil
+	}
+	content, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(line); err != nil {
+			return nil, fmt.Errorf("endpoint(%s) in %s is invalid: %s", line, p.Path, err.Error())
+		}
+		endpoints = append(endpoints, line)
+	}
+	p.endpoints, p.modTime, p.size = endpoints, info.ModTime(), info.Size()
+	return endpoints, nil
+}
+
+// HTTPProvider resolves endpoints by polling a service registry over HTTP.
+// The registry responds to GET requests to Url with 200 and a JSON array of endpoints, such as ["10.0.0.1:8080","10.0.0.2:8080"].
+type HTTPProvider struct {
+	// Url is the url of the registry.
+	Url string
+	// Header is added to the requests to the registry, such as for authorization.
+	Header http.Header
+	// Client sends the requests to the registry. When nil, http.DefaultClient will be used.
+	Client *http.Client
+}
+
+// Endpoints implements DiscoveryProvider.
+func (p *HTTPProvider) Endpoints(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range p.Header {
+		req.Header[k] = v
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry(%s) responded %d", p.Url, resp.StatusCode)
+	}
+	var endpoints []string
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, fmt.Errorf("registry(%s) response is invalid: %s", p.Url, err.Error())
+	}
+	for _, endpoint := range endpoints {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return nil, fmt.Errorf("endpoint(%s) from registry(%s) is invalid: %s", endpoint, p.Url, err.Error())
+		}
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,98 @@
+package gag
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiscoveryRoutesToEndpoints(t *testing.T) {
+	first := httptest.NewServer(jsonHandler(`{"from":"first"}`))
+	defer first.Close()
+	second := httptest.NewServer(jsonHandler(`{"from":"second"}`))
+	defer second.Close()
+	host := func(s *httptest.Server) string {
+		u, _ := url.Parse(s.URL)
+		return u.Host
+	}
+
+	path := filepath.Join(t.TempDir(), "endpoints")
+	if err := os.WriteFile(path, []byte("# users\n"+host(first)+"\n"), 0o644); err != nil {
+		t.Fatalf("error writing endpoints: %v", err)
+	}
+	discovery := &Discovery{Provider: &FileProvider{Path: path}, Interval: 10 * time.Millisecond}
+	g := NewGag(Config{})
+	g.Conditions().Path("/users").Route(&RouteRequest{Url: "http://users.internal/users", HttpMethod: http.MethodGet, Discovery: discovery}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/users")
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	if err := validateResponse(res, http.StatusOK, `{"from":"first"}`); err != nil {
+		t.Error(err)
+	}
+
+	if err := os.WriteFile(path, []byte(host(first)+"\n"+host(second)+"\n"), 0o644); err != nil {
+		t.Fatalf("error writing endpoints: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(discovery.Endpoints()) != 2 && time.Now().Before(deadline) {
+		http.Get(s.URL + "/users")
+		time.Sleep(20 * time.Millisecond)
+	}
+	bodies := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		res, err := http.Get(s.URL + "/users")
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		var body map[string]string
+		decodeJSON(t, res, &body)
+		bodies[body["from"]] = true
+	}
+	if !bodies["first"] || !bodies["second"] {
+		t.Errorf("expected requests to be routed to both endpoints, got %v", bodies)
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`["10.0.0.1:8080","10.0.0.2:8080"]`))
+	}))
+	defer registry.Close()
+
+	p := &HTTPProvider{Url: registry.URL, Header: http.Header{"Authorization": {"Bearer secret"}}}
+	endpoints, err := p.Endpoints(context.Background())
+	if err != nil {
+		t.Fatalf("error resolving endpoints: %v", err)
+	}
+	if strings.Join(endpoints, ",") != "10.0.0.1:8080,10.0.0.2:8080" {
+		t.Errorf("unexpected endpoints: %v", endpoints)
+	}
+	if _, err := (&HTTPProvider{Url: registry.URL}).Endpoints(context.Background()); err == nil {
+		t.Error("expected error when registry responds 401")
+	}
+}
+
+func TestDNSProvider(t *testing.T) {
+	endpoints, err := (&DNSProvider{Name: "localhost", Port: 8080}).Endpoints(context.Background())
+	if err != nil {
+		t.Skipf("localhost cannot be resolved: %v", err)
+	}
+	if !slices.Contains(endpoints, "127.0.0.1:8080") {
+		t.Errorf("expected 127.0.0.1:8080 in endpoints, got %v", endpoints)
+	}
+}
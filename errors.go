@@ -0,0 +1,267 @@
+package gag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrorKind identifies the kind of a GatewayError.
+type ErrorKind string
+
+// Kinds of GatewayError written by Gag.
+const (
+	ErrorNotFound             ErrorKind = "not-found"
+	ErrorMethodNotAllowed     ErrorKind = "method-not-allowed"
+	ErrorBadHeader            ErrorKind = "bad-header"
+	ErrorBadRequest           ErrorKind = "bad-request"
+	ErrorInvalidRequest       ErrorKind = "invalid-request"
+	ErrorBodyTooLarge         ErrorKind = "body-too-large"
+	ErrorUnsupportedMediaType ErrorKind = "unsupported-media-type"
+	ErrorForbidden            ErrorKind = "forbidden"
+	ErrorConflict             ErrorKind = "conflict"
+	ErrorRateLimited          ErrorKind = "rate-limited"
+	ErrorUpstreamTimeout      ErrorKind = "upstream-timeout"
+	ErrorUpstreamUnavailable  ErrorKind = "upstream-unavailable"
+	ErrorInternal             ErrorKind = "internal"
+)
+
+// GatewayError is an error response generated by Gag itself, rather than relayed from an upstream.
+type GatewayError struct {
+	// StatusCode is the status code of the response.
+	StatusCode int
+	// Kind identifies the kind of the error.
+	Kind ErrorKind
+	// Detail describes the error. It is safe to be written to clients.
+	Detail string
+	// Err is the internal cause of the error, such as a dial error of an upstream.
+	// It is only logged, and never written to clients.
+	Err error
+	// Violations are the parts of the request violating its RequestValidation, for ErrorInvalidRequest.
+	Violations []Violation
+}
+
+func (e *GatewayError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Detail, e.Err.Error())
+	}
+	return fmt.Sprintf("%d %s", e.StatusCode, e.Detail)
+}
+
+func (e *GatewayError) Unwrap() error {
+	return e.Err
+}
+
+// Title returns the status text of StatusCode, such as "Bad Gateway".
+func (e *GatewayError) Title() string {
+	return http.StatusText(e.StatusCode)
+}
+
+// ErrorHandler writes a GatewayError as the response of r.
+// Configure ErrorHandler using Config.ErrorHandler.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err *GatewayError)
+
+// Problem is a problem details object defined by RFC 7807, written by ProblemJSON.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Violations is an extension member listing the Violations of an ErrorInvalidRequest.
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// ProblemTypePrefix prefixes the ErrorKind in the type of Problems written by ProblemJSON.
+const ProblemTypePrefix = "urn:gag:error:"
+
+// ProblemJSON is an ErrorHandler writing errors as RFC 7807 problem details
+// with "application/problem+json" Content-Type.
+func ProblemJSON(w http.ResponseWriter, r *http.Request, err *GatewayError) {
+	writeProblem(w, err.StatusCode, Problem{
+		Type:       ProblemTypePrefix + string(err.Kind),
+		Title:      err.Title(),
+		Status:     err.StatusCode,
+		Detail:     err.Detail,
+		Instance:   r.URL.Path,
+		Violations: err.Violations,
+	})
+}
+
+func writeProblem(w http.ResponseWriter, statusCode int, problem interface{}) {
+	res, err := json.Marshal(problem)
+	if err != nil {
+		writePlainError(w, &GatewayError{StatusCode: http.StatusInternalServerError, Detail: "internal server error"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(statusCode)
+	w.Write(res)
+}
+
+// Template is implemented by both text/template and html/template templates.
+type Template interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// ErrorTemplateData is the data which the template of ErrorTemplate is executed with.
+type ErrorTemplateData struct {
+	StatusCode int
+	Title      string
+	Kind       ErrorKind
+	Detail     string
+	Path       string
+	Violations []Violation
+}
+
+// ErrorTemplate returns an ErrorHandler writing errors by executing tmpl with ErrorTemplateData,
+// with contentType as the Content-Type of the response.
+// If tmpl fails, the error is written as plain text.
+func ErrorTemplate(tmpl Template, contentType string) ErrorHandler {
+	return func(w http.ResponseWriter, r *http.Request, err *GatewayError) {
+		var b bytes.Buffer
+		data := ErrorTemplateData{
+			StatusCode: err.StatusCode,
+			Title:      err.Title(),
+			Kind:       err.Kind,
+			Detail:     err.Detail,
+			Path:       r.URL.Path,
+			Violations: err.Violations,
+		}
+		if tmplErr := tmpl.Execute(&b, data); tmplErr != nil {
+			logger{}.Println(fmt.Sprintf("error executing error template: %s", tmplErr.Error()))
+			writePlainError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(err.StatusCode)
+		w.Write(b.Bytes())
+	}
+}
+
+// errorHandlerKey is the context key of the ErrorHandler of the Gag serving a request.
+type errorHandlerKey struct{}
+
+// withErrorHandler returns a shallow copy of r carrying h, which WriteError uses.
+func withErrorHandler(r *http.Request, h ErrorHandler) *http.Request {
+	if h == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), errorHandlerKey{}, h))
+}
+
+// WriteError writes err with the ErrorHandler of the Gag serving r, and logs the internal cause of err.
+// Middlewares, such as rate limiters, can use WriteError so that their errors are rendered like those of Gag.
+func WriteError(w http.ResponseWriter, r *http.Request, err *GatewayError) {
+	if err.Err != nil {
+		logger{}.Println(fmt.Sprintf("%s %s: %s", r.Method, r.URL.Path, err.Error()))
+	}
+	if h, ok := r.Context().Value(errorHandlerKey{}).(ErrorHandler); ok {
+		h(w, r, err)
+		return
+	}
+	writePlainError(w, err)
+}
+
+// writePlainError writes err as plain text, such as "404 not found". It is the default ErrorHandler.
+func writePlainError(w http.ResponseWriter, err *GatewayError) {
+	w.WriteHeader(err.StatusCode)
+	w.Write([]byte(fmt.Sprintf("%d %s", err.StatusCode, err.Detail)))
+}
+
+// isTimeoutError reports whether err is caused by a timeout.
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func respond500(w http.ResponseWriter, r *http.Request, err error) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusInternalServerError, Kind: ErrorInternal, Detail: "internal server error", Err: err})
+}
+
+// respondUpstreamError responds 413 if err is caused by a request body exceeding its limit,
+// 400 if it is caused by a request body which cannot be transformed,
+// 504 if an upstream timed out, or 502 otherwise.
+func respondUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respond413(w, r, maxBytesErr.Limit)
+		return
+	}
+	var badBodyErr *errBadRequestBody
+	if errors.As(err, &badBodyErr) {
+		respond400(w, r, badBodyErr)
+		return
+	}
+	if errors.Is(err, errCircuitOpen) {
+		respond503CircuitOpen(w, r)
+		return
+	}
+	if isTimeoutError(err) {
+		respond504(w, r, err)
+		return
+	}
+	respond502(w, r, err)
+}
+
+func respond503CircuitOpen(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusServiceUnavailable, Kind: ErrorUpstreamUnavailable, Detail: "upstream circuit open"})
+}
+
+func respond413(w http.ResponseWriter, r *http.Request, limit int64) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusRequestEntityTooLarge, Kind: ErrorBodyTooLarge, Detail: fmt.Sprintf("request body larger than %d bytes", limit)})
+}
+
+func respond502(w http.ResponseWriter, r *http.Request, err error) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusBadGateway, Kind: ErrorUpstreamUnavailable, Detail: "upstream unavailable", Err: err})
+}
+
+func respond504(w http.ResponseWriter, r *http.Request, err error) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusGatewayTimeout, Kind: ErrorUpstreamTimeout, Detail: "upstream timed out", Err: err})
+}
+
+func respond404(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusNotFound, Kind: ErrorNotFound, Detail: "not found"})
+}
+
+func respond400(w http.ResponseWriter, r *http.Request, err error) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusBadRequest, Kind: ErrorBadRequest, Detail: err.Error()})
+}
+
+func respond400Violations(w http.ResponseWriter, r *http.Request, violations []Violation) {
+	details := make([]string, len(violations))
+	for i, v := range violations {
+		details[i] = v.String()
+	}
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusBadRequest, Kind: ErrorInvalidRequest, Detail: "request is invalid: " + strings.Join(details, "; "), Violations: violations})
+}
+
+func respond409(w http.ResponseWriter, r *http.Request, err error) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusConflict, Kind: ErrorConflict, Detail: err.Error()})
+}
+
+func respond405(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusMethodNotAllowed, Kind: ErrorMethodNotAllowed, Detail: fmt.Sprintf("method(%s) not allowed", r.Method)})
+}
+
+func respond415(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusUnsupportedMediaType, Kind: ErrorUnsupportedMediaType, Detail: fmt.Sprintf("content type(%s) not supported", r.Header.Get("Content-Type"))})
+}
+
+func respond403(w http.ResponseWriter, r *http.Request, reason string) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusForbidden, Kind: ErrorForbidden, Detail: reason})
+}
+
+func respond400BadHeader(w http.ResponseWriter, r *http.Request, header string) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusBadRequest, Kind: ErrorBadHeader, Detail: fmt.Sprintf("header(%s) not provided", header)})
+}
+
+func respond400BadHeaderValue(w http.ResponseWriter, r *http.Request, hv *headerValue) {
+	WriteError(w, r, &GatewayError{StatusCode: http.StatusBadRequest, Kind: ErrorBadHeader, Detail: fmt.Sprintf("header(%s) with value(%s) not provided", hv.Key, hv.Value)})
+}
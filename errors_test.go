@@ -0,0 +1,88 @@
+package gag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestProblemJSONErrors(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{}`))
+	upstream.Close()
+
+	g := NewGag(Config{ErrorHandler: ProblemJSON})
+	g.Conditions().
+		Path("/post").Method(http.MethodPost).HandlerFunc(sampleHandler(), g).
+		Path("/down").Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		path     string
+		expected Problem
+	}{
+		{"/missing", Problem{Type: "urn:gag:error:not-found", Title: "Not Found", Status: http.StatusNotFound, Detail: "not found", Instance: "/missing"}},
+		{"/post", Problem{Type: "urn:gag:error:method-not-allowed", Title: "Method Not Allowed", Status: http.StatusMethodNotAllowed, Detail: "method(GET) not allowed", Instance: "/post"}},
+		{"/down", Problem{Type: "urn:gag:error:upstream-unavailable", Title: "Bad Gateway", Status: http.StatusBadGateway, Detail: "upstream unavailable", Instance: "/down"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			res, err := http.Get(s.URL + tt.path)
+			if err != nil {
+				t.Fatalf("error doing request: %v", err)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("expected Content-Type application/problem+json, got %s", ct)
+			}
+			if res.StatusCode != tt.expected.Status {
+				t.Errorf("expected status code %d, got %d", tt.expected.Status, res.StatusCode)
+			}
+			var problem Problem
+			decodeJSON(t, res, &problem)
+			if !reflect.DeepEqual(problem, tt.expected) {
+				t.Errorf("expected problem %+v, got %+v", tt.expected, problem)
+			}
+		})
+	}
+}
+
+func TestUpstreamErrorsAreNotLeaked(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{}`))
+	upstream.Close()
+
+	g := NewGag(Config{})
+	g.Conditions().Path("/down").Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/down")
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	if err := validateResponse(res, http.StatusBadGateway, "502 upstream unavailable"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestErrorTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("error").Parse(`<error code="{{.StatusCode}}" kind="{{.Kind}}">{{.Title}}: {{.Detail}}</error>`))
+	g := NewGag(Config{ErrorHandler: ErrorTemplate(tmpl, "application/xml")})
+	g.Conditions().Path("/header").HasHeader("X-Key").HandlerFunc(sampleHandler(), g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/header")
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+		t.Errorf("expected Content-Type application/xml, got %s", ct)
+	}
+	expected := `<error code="400" kind="bad-header">Bad Request: header(X-Key) not provided</error>`
+	if err := validateResponse(res, http.StatusBadRequest, expected); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,11 +1,17 @@
 package gag
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	gorillaMux "github.com/gorilla/mux"
 )
@@ -15,6 +21,62 @@ type Config struct {
 	// Port defines which port number will be used to listen to HTTP requests.
 	// When given 0, Gag will start on random available port.
 	Port uint16
+	// CORS is the CORS configuration applied to all Conditions which don't have their own.
+	// When nil, CORS headers are written only for Conditions configured with Condition.CORS().
+	CORS *CORSConfig
+	// TLSConfig is the TLS configuration of the listener.
+	// When either TLSConfig or TLSCertFile and TLSKeyFile are set, Gag serves HTTPS.
+	TLSConfig *tls.Config
+	// TLSCertFile is the path of the certificate file used to serve HTTPS.
+	TLSCertFile string
+	// TLSKeyFile is the path of the private key file matching TLSCertFile.
+	TLSKeyFile string
+	// HTTP2 enables HTTP/2 over TLS. It requires TLS to be configured.
+	HTTP2 bool
+	// H2C enables HTTP/2 in cleartext(h2c) with prior knowledge, along with HTTP/1.1.
+	H2C bool
+	// MaxConcurrentStreams is the maximum number of concurrent streams per HTTP/2 connection.
+	// When 0, the default of net/http will be used.
+	MaxConcurrentStreams int
+	// MaxHeaderBytes is the maximum size of request headers, including the request line.
+	// When 0, http.DefaultMaxHeaderBytes will be used.
+	MaxHeaderBytes int
+	// ReadHeaderTimeout is the maximum duration for reading request headers.
+	// When 0, ReadTimeout will be used.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is the maximum duration for reading the entire request, including the body.
+	// When 0, there is no timeout.
+	ReadTimeout time.Duration
+	// WriteTimeout is the maximum duration before timing out writes of the response.
+	// When 0, there is no timeout.
+	WriteTimeout time.Duration
+	// IdleTimeout is the maximum amount of time to wait for the next request on keep-alive connections.
+	// When 0, ReadTimeout will be used.
+	IdleTimeout time.Duration
+	// MaxRequestBodyBytes is the maximum size of request bodies, applied to all Conditions
+	// which don't have their own limit. Requests exceeding it are responded with 413.
+	// When 0, request bodies are not limited.
+	MaxRequestBodyBytes int64
+	// Admin configures the admin API server. When nil, the admin API is not served.
+	Admin *AdminConfig
+	// Health configures the liveness and readiness endpoints. When nil, the endpoints are not served.
+	Health *HealthConfig
+	// ErrorHandler writes the errors generated by Gag, such as unmatched paths and unavailable upstreams.
+	// Use ProblemJSON for RFC 7807 problem details, or ErrorTemplate for a template of your own.
+	// When nil, errors are written as plain text, such as "404 not found".
+	ErrorHandler ErrorHandler
+	// Middlewares wrap the whole router at PhasePreRoute, so that they run for every request before its path is matched,
+	// including requests responded with 404 or 405, and the liveness and readiness endpoints.
+	// They run in the order given, so that the first middleware runs first, unlike Condition.Middlewares(),
+	// and before the middlewares of Conditions.
+	Middlewares []Middleware
+	// IPFilter allows or denies every request by the IP address of its client, before Middlewares run.
+	// It applies to the liveness and readiness endpoints too. When nil, requests are not filtered gateway-wide.
+	IPFilter *IPFilter
+	// TrustedProxies are the CIDRs or addresses of proxies in front of Gag, such as load balancers,
+	// whose Forwarded and X-Forwarded-For headers are trusted to resolve the IP address of the client.
+	// When empty, the client is always the immediate peer. See ClientIP.
+	TrustedProxies []string
 }
 
 // Gag is a struct that contains all the necessary properties to run Gag.
@@ -23,8 +85,18 @@ type Gag struct {
 	l          net.Listener
 	s          *http.Server
 	conditions []*Condition
-	mux        *gorillaMux.Router
+	router     http.Handler
 	log        logger
+	cors       *CORSConfig
+	cfg        Config
+	// mu guards conditions, router, version, nextID, s and admin, which can be changed while serving.
+	mu        sync.RWMutex
+	version   int
+	nextID    int
+	admin     *http.Server
+	readiness readiness
+	// trustedProxies are the parsed Config.TrustedProxies.
+	trustedProxies ipSet
 }
 
 func (g *Gag) listenHTTP(port uint16) error {
@@ -49,37 +121,111 @@ func (g *Gag) listenHTTP(port uint16) error {
 }
 
 func (g *Gag) newServer() error {
+	g.mu.Lock()
+	g.s = g.httpServer()
+	g.mu.Unlock()
+	g.readiness.listening.Store(true)
+	defer g.readiness.listening.Store(false)
 	if err := g.serve(); err != nil {
 		return err
 	}
 	return nil
 }
 
+// httpServer returns an http.Server serving the Conditions of g, configured with the protocols and limits of g.cfg.
+func (g *Gag) httpServer() *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(g.cfg.HTTP2)
+	protocols.SetUnencryptedHTTP2(g.cfg.H2C)
+
+	s := &http.Server{
+		Handler:           http.HandlerFunc(g.serveHTTP),
+		TLSConfig:         g.cfg.TLSConfig,
+		ReadHeaderTimeout: g.cfg.ReadHeaderTimeout,
+		ReadTimeout:       g.cfg.ReadTimeout,
+		WriteTimeout:      g.cfg.WriteTimeout,
+		IdleTimeout:       g.cfg.IdleTimeout,
+		MaxHeaderBytes:    g.cfg.MaxHeaderBytes,
+		Protocols:         protocols,
+	}
+	if g.cfg.HTTP2 || g.cfg.H2C {
+		s.HTTP2 = &http.HTTP2Config{
+			MaxConcurrentStreams: g.cfg.MaxConcurrentStreams,
+		}
+	}
+	return s
+}
+
+func (g *Gag) tlsEnabled() bool {
+	return g.cfg.TLSConfig != nil || (g.cfg.TLSCertFile != "" && g.cfg.TLSKeyFile != "")
+}
+
 func (g *Gag) serve() error {
-	if err := http.Serve(g.l, g.mux); err != nil {
+	var err error
+	if g.tlsEnabled() {
+		err = g.s.ServeTLS(g.l, g.cfg.TLSCertFile, g.cfg.TLSKeyFile)
+	} else {
+		err = g.s.Serve(g.l)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		fmt.Printf("err in http.Serve(): %s\n", err.Error())
 		return err
 	}
 	return nil
 }
 
-// Serve starts an HTTP server.
+// Serve starts an HTTP server, or an HTTPS server if TLS is configured.
 func (g *Gag) Serve() error {
+	if g.cfg.HTTP2 && !g.tlsEnabled() {
+		return errors.New("HTTP2 requires TLS, use H2C for HTTP/2 in cleartext")
+	}
+	if err := g.validateConfig(); err != nil {
+		return err
+	}
 	if err := g.validateConditions(); err != nil {
 		return err
 	}
+	g.readiness.configLoaded.Store(true)
+	if g.cfg.Admin != nil {
+		if err := g.serveAdmin(); err != nil {
+			return err
+		}
+	}
 	if err := g.listenHTTP(g.port); err != nil {
+		if g.admin != nil {
+			// The admin API should not outlive the failed Gag.
+			g.admin.Close()
+		}
 		return err
 	}
 	return nil
 }
 
+// Handler validates the Conditions and returns an http.Handler serving them,
+// so that Gag can be served by another server, such as an httptest.Server.
+func (g *Gag) Handler() (http.Handler, error) {
+	if err := g.validateConfig(); err != nil {
+		return nil, err
+	}
+	if err := g.validateConditions(); err != nil {
+		return nil, err
+	}
+	g.readiness.configLoaded.Store(true)
+	// The listener is owned by the server serving the Handler.
+	g.readiness.listening.Store(true)
+	g.configureHandler()
+	return http.HandlerFunc(g.serveHTTP), nil
+}
+
 // NewGag returns a new Gag instance.
 func NewGag(cfg Config) *Gag {
 	g := Gag{
 		port:       cfg.Port,
 		conditions: []*Condition{},
 		log:        logger{},
+		cors:       cfg.CORS,
+		cfg:        cfg,
 	}
 	return &g
 }
@@ -87,164 +233,125 @@ func NewGag(cfg Config) *Gag {
 // Conditions is used to add Conditions to Gag.
 // For example:
 // 	g := NewGag(Config{})
-// 	g.Conditions().Path("/foo").Method(http.MethodGet).Route(&gag.RouteRequest{Url: "/route-to", HttpMethod: http.MethodGet}, g)
+// 	g.Conditions().Path("/foo").Method(http.MethodGet).Route(&gag.RouteRequest{Url: "http://127.0.0.1:8081/route-to", HttpMethod: http.MethodGet}, g)
 func (g *Gag) Conditions() *Condition {
 	return &Condition{}
 }
 
-func (g *Gag) validateConditions() error {
-	for _, c := range g.conditions {
-		if c.path == "" {
-			return errors.New("path cannot be \"\"")
-		}
-	}
-	return nil
+func (g *Gag) configureHandler() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.configureHandlerLocked()
 }
 
-func (g *Gag) configureHandler() {
+// configureHandlerLocked builds the router from the current Conditions. g.mu must be held.
+func (g *Gag) configureHandlerLocked() {
 	g.log.Println(fmt.Sprintf("total conditions found: %d", len(g.conditions)))
 	mux := gorillaMux.NewRouter()
+	mux.NotFoundHandler = http.HandlerFunc(respond404)
+	if g.cfg.Health != nil {
+		g.registerHealthHandlers(mux)
+	}
+	dispatchers := map[methodDispatcherKey]*methodDispatcher{}
 	for _, c := range g.conditions {
-		configuredMux := configureMuxHandlers(c)
-		mux.Handle(c.path, configuredMux)
+		if c.id == 0 {
+			g.nextID++
+			c.id = g.nextID
+		}
+		if c.disabled {
+			g.log.Println(fmt.Sprintf("path %s disabled", c.path))
+			continue
+		}
+		var h http.Handler = g.configureMuxHandlers(c)
+		if c.pathItem != nil {
+			key := methodDispatcherKey{c.pathItem, c.path}
+			d, ok := dispatchers[key]
+			if !ok {
+				d = &methodDispatcher{}
+				dispatchers[key] = d
+			}
+			d.add(c.httpMethod, h)
+			if ok {
+				g.log.Println(fmt.Sprintf("path %s registered for method %s", c.path, c.httpMethod))
+				continue
+			}
+			h = d
+		}
+		mux.Handle(c.path, h)
 		g.log.Println(fmt.Sprintf("path %s registered", c.path))
 	}
-	g.mux = mux
+	if len(g.cfg.Middlewares) > 0 {
+		g.router = chain(mux, g.cfg.Middlewares)
+	} else {
+		g.router = mux
+	}
+	g.version++
 }
 
-func configureMuxHandlers(c *Condition) *gorillaMux.Router {
+// serveHTTP dispatches r to the router built from the current Conditions.
+func (g *Gag) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.RLock()
+	router := g.router
+	g.mu.RUnlock()
+	r = withClientIP(withErrorHandler(r, g.cfg.ErrorHandler), g.trustedProxies)
+	if g.cfg.IPFilter != nil && !g.cfg.IPFilter.serve(w, r) {
+		return
+	}
+	router.ServeHTTP(w, r)
+}
+
+func (g *Gag) configureMuxHandlers(c *Condition) *gorillaMux.Router {
 	mux := gorillaMux.NewRouter()
-	var h http.Handler
+	// The handler is wrapped from the innermost phase, so that the phases run in the order documented by Phase.
+	var h http.Handler = chain(conditionHandlerFunc(c), c.phases[PhasePreUpstream])
+	if c.validation != nil {
+		h = c.validation.middleware()(h)
+	}
 	if len(c.middlewares.middlewares) > 0 {
-		if c.handlerFunc != nil {
-			h = c.middlewares.wrap(c.handlerFunc, h)
-		} else {
-			h = c.middlewares.wrap(func(w http.ResponseWriter, r *http.Request) {
-				client := http.Client{Timeout: c.routeRequest.Timeout}
-				if c.routeRequest.PassRequestBody {
-					defer r.Body.Close()
-					reqBody := r.Body
-					req, err := http.NewRequestWithContext(r.Context(), c.routeRequest.HttpMethod, c.routeRequest.Url, reqBody)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					req.Header.Set("Content-Type", "application/json")
-					resp, err := client.Do(req)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					defer resp.Body.Close()
-					bodyBytes, err := io.ReadAll(resp.Body)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(resp.StatusCode)
-					w.Write(bodyBytes)
-					return
-				} else {
-					req, err := http.NewRequestWithContext(r.Context(), c.routeRequest.HttpMethod, c.routeRequest.Url, nil)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					resp, err := client.Do(req)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					defer resp.Body.Close()
-					bodyBytes, err := io.ReadAll(resp.Body)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(resp.StatusCode)
-					w.Write(bodyBytes)
-					return
-				}
-			}, h)
-		}
-	} else {
-		if c.handlerFunc != nil {
-			h = c.handlerFunc
-		} else {
-			h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				client := http.Client{Timeout: c.routeRequest.Timeout}
-				if c.routeRequest.PassRequestBody {
-					defer r.Body.Close()
-					reqBody := r.Body
-					req, err := http.NewRequestWithContext(r.Context(), c.routeRequest.HttpMethod, c.routeRequest.Url, reqBody)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					req.Header.Set("Content-Type", "application/json")
-					resp, err := client.Do(req)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					defer resp.Body.Close()
-					bodyBytes, err := io.ReadAll(resp.Body)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(resp.StatusCode)
-					w.Write(bodyBytes)
-					return
-				} else {
-					req, err := http.NewRequestWithContext(r.Context(), c.routeRequest.HttpMethod, c.routeRequest.Url, nil)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					resp, err := client.Do(req)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					defer resp.Body.Close()
-					bodyBytes, err := io.ReadAll(resp.Body)
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						w.Write([]byte(err.Error()))
-						return
-					}
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(resp.StatusCode)
-					w.Write(bodyBytes)
-					return
-				}
-			})
-		}
+		h = c.middlewares.wrap(h.ServeHTTP, nil)
+	}
+	h = chain(h, c.phases[PhaseAuth])
+	h = chain(h, c.phases[PhaseResponse])
+	h = chain(h, c.matchers)
+
+	cors := c.cors
+	if cors == nil {
+		cors = g.cors
+	}
+
+	maxBodyBytes := c.maxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = g.cfg.MaxRequestBodyBytes
 	}
 
 	mux.HandleFunc(c.path, func(w http.ResponseWriter, r *http.Request) {
+		// Denied clients are responded with 403 before CORS and method matching, which would reveal the allowed methods.
+		for _, f := range c.ipFilters {
+			if !f.serve(w, r) {
+				return
+			}
+		}
+		if maxBodyBytes > 0 {
+			if r.ContentLength > maxBodyBytes {
+				respond413(w, r, maxBodyBytes)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		}
+		if cors != nil {
+			if isPreflight(r) {
+				cors.handlePreflight(w, r, c.httpMethod)
+				return
+			}
+			cors.writeHeaders(w, r)
+		}
 		if (c.httpMethod != "" && c.httpMethod == r.Method) || (c.httpMethod == "") {
 			if _, ok := r.Header[c.header]; ok {
 				if c.headerValue != nil {
 					if values, ok := r.Header[c.headerValue.Key]; hasHeaderValue(c.headerValue.Value, values) && ok {
 						h.ServeHTTP(w, r)
 					} else {
-						respond400BadHeaderValue(w, c.headerValue)
+						respond400BadHeaderValue(w, r, c.headerValue)
 					}
 				} else {
 					h.ServeHTTP(w, r)
@@ -254,41 +361,166 @@ func configureMuxHandlers(c *Condition) *gorillaMux.Router {
 					if values := r.Header[c.headerValue.Key]; hasHeaderValue(c.headerValue.Value, values) {
 						h.ServeHTTP(w, r)
 					} else {
-						respond400BadHeaderValue(w, c.headerValue)
+						respond400BadHeaderValue(w, r, c.headerValue)
 					}
 				} else {
 					h.ServeHTTP(w, r)
 				}
 			} else {
-				respond400BadHeader(w, c.header)
+				respond400BadHeader(w, r, c.header)
 			}
 		} else {
-			respond405(w, r.Method)
+			respond405(w, r)
 		}
 	})
 	return mux
 }
 
-func hasHeaderValue(value string, values []string) bool {
-	for _, v := range values {
-		if v == value {
-			return true
+// conditionHandlerFunc returns the handler function which will serve requests matching c.
+func conditionHandlerFunc(c *Condition) http.HandlerFunc {
+	if c.handlerFunc != nil {
+		return c.handlerFunc
+	}
+	if c.composition != nil {
+		return c.composition.handlerFunc()
+	}
+	if c.grpcRoute != nil {
+		return c.grpcRoute.handlerFunc()
+	}
+	return routeHandlerFunc(c.routeRequest, upstreamWriter{hooks: c.upstreamHooks, transform: c.routeRequest.ResponseTransform})
+}
+
+// UpstreamResponse is a response received from an upstream service.
+type UpstreamResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// routeHandlerFunc returns a handler function which routes requests to rr.Url, writing responses with uw.
+func routeHandlerFunc(rr *RouteRequest, uw upstreamWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = rr.withUpstreamVersion(r)
+		if isUpgradeRequest(r) {
+			rr.tunnel(w, r)
+			return
+		}
+		if rr.Cache != nil && r.Method == http.MethodGet {
+			rr.Cache.serve(w, r, rr, uw)
+			return
 		}
+		res, err := rr.roundTrip(r.Context(), r, nil)
+		if err != nil {
+			respondUpstreamError(w, r, err)
+			return
+		}
+		uw.write(w, r, res)
 	}
-	return false
 }
 
-func respond405(w http.ResponseWriter, method string) {
-	w.WriteHeader(http.StatusMethodNotAllowed)
-	w.Write([]byte(fmt.Sprintf("405 method(%s) not allowed", method)))
+// target returns the url which r will be routed to: the Url of the version chosen for r, or rr.Url,
+// with its placeholders replaced by the path variables of r.
+func (rr *RouteRequest) target(r *http.Request) (string, error) {
+	target := rr.Url
+	if v, ok := r.Context().Value(upstreamVersionKey{}).(*UpstreamVersion); ok {
+		target = v.Url
+	}
+	if !strings.Contains(target, "{") {
+		return target, nil
+	}
+	return expandUrl(target, gorillaMux.Vars(r), nil)
+}
+
+// roundTrip sends r to rr.Url and reads the whole response.
+// header is added to the request which will be sent to rr.Url.
+func (rr *RouteRequest) roundTrip(ctx context.Context, r *http.Request, header http.Header) (res *UpstreamResponse, err error) {
+	target, err := rr.target(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := rr.health.allow(rr.CircuitBreaker); err != nil {
+		return nil, err
+	}
+	mirror := rr.Mirror != nil && rr.Mirror.sample()
+	var reqBody io.Reader
+	var body []byte
+	contentType := "application/json"
+	if rr.PassRequestBody {
+		defer r.Body.Close()
+		reqBody = r.Body
+		switch {
+		case rr.RequestTransform != nil:
+			if body, contentType, err = rr.RequestTransform.apply(r); err != nil {
+				return nil, err
+			}
+			reqBody = bytes.NewReader(body)
+		case mirror || rr.Hedge != nil:
+			// The body is sent to the shadow upstream or by the hedged request as well, so it is read beforehand.
+			if body, err = io.ReadAll(r.Body); err != nil {
+				return nil, err
+			}
+			reqBody = bytes.NewReader(body)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, rr.HttpMethod, rr.endpoint(target), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if rr.PassRequestBody {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if mirror {
+		primary := rr.Mirror.send(ctx, r, rr, req.Header.Clone(), body)
+		start := time.Now()
+		defer func() {
+			result := mirrorResult{latency: time.Since(start), err: err}
+			if res != nil {
+				result.statusCode = res.StatusCode
+			}
+			primary <- result
+		}()
+	}
+	if rr.Hedge != nil {
+		return rr.Hedge.do(rr, req, body, target)
+	}
+	return rr.send(req)
 }
 
-func respond400BadHeader(w http.ResponseWriter, header string) {
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte(fmt.Sprintf("400 header(%s) not provided", header)))
+// send sends req and reads the whole response, recording the health of the upstream.
+func (rr *RouteRequest) send(req *http.Request) (*UpstreamResponse, error) {
+	client := http.Client{Timeout: rr.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		rr.recordFailure(req, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		rr.recordFailure(req, err)
+		return nil, err
+	}
+	rr.health.record(resp.StatusCode, nil, rr.CircuitBreaker)
+	return &UpstreamResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: bodyBytes}, nil
+}
+
+// recordFailure records err of req in the health of the upstream,
+// unless req was canceled, such as the slower of hedged requests, which says nothing about the upstream.
+func (rr *RouteRequest) recordFailure(req *http.Request, err error) {
+	if errors.Is(req.Context().Err(), context.Canceled) {
+		return
+	}
+	rr.health.record(0, err, rr.CircuitBreaker)
 }
 
-func respond400BadHeaderValue(w http.ResponseWriter, hv *headerValue) {
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte(fmt.Sprintf("400 header(%s) with value(%s) not provided", hv.Key, hv.Value)))
+func hasHeaderValue(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
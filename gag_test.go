@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -29,23 +32,8 @@ func sampleHandler() http.HandlerFunc {
 var port uint16
 var c *http.Client
 
-func startGag(g *Gag) func() {
-	ch := make(chan struct{}, 1)
-	cancel := func() {
-		close(ch)
-	}
-	go func() {
-		err := g.Serve()
-		if err != nil {
-			panic(err)
-		}
-	}()
-	port = g.port
-	return cancel
-}
-
 func TestMain(m *testing.M) {
-	g := NewGag(Config{Port: 8080})
+	g := NewGag(Config{})
 	g.Conditions().
 		Path("/a").Method(http.MethodGet).HandlerFunc(sampleHandler(), g).
 		Path("/b").Method(http.MethodPost).HandlerFunc(sampleHandler(), g).
@@ -53,13 +41,23 @@ func TestMain(m *testing.M) {
 		Path("/d").Method(http.MethodGet).HasHeaderValue("X-Key", "someValue").HandlerFunc(sampleHandler(), g).
 		Path("/e").Method(http.MethodGet).HasHeader("X-Key").HasHeaderValue("X-Key-Two", "someValue").HandlerFunc(sampleHandler(), g)
 
-	cancel := startGag(g)
+	s := newTestServer(g)
+	port = uint16(s.Listener.Addr().(*net.TCPAddr).Port)
 	c = http.DefaultClient
 	exitVal := m.Run()
-	cancel()
+	s.Close()
 	os.Exit(exitVal)
 }
 
+// newTestServer starts an httptest.Server serving the Conditions of g on a random port.
+func newTestServer(g *Gag) *httptest.Server {
+	h, err := g.Handler()
+	if err != nil {
+		panic(err)
+	}
+	return httptest.NewServer(h)
+}
+
 func TestCorrectHttpMethodHandlingSuccess(t *testing.T) {
 	r, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/a", port), nil)
 	if err != nil {
@@ -301,3 +299,45 @@ func validateResponse(r *http.Response, statusCode int, body string) error {
 
 	return nil
 }
+
+func orderMiddleware(name string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", name)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestGatewayMiddlewares(t *testing.T) {
+	g := NewGag(Config{Middlewares: []Middleware{orderMiddleware("global-1"), orderMiddleware("global-2")}})
+	g.Conditions().
+		Path("/get").Method(http.MethodGet).Middlewares(orderMiddleware("condition")).HandlerFunc(sampleHandler(), g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+		order      []string
+	}{
+		{http.MethodGet, "/get", http.StatusOK, []string{"global-1", "global-2", "condition"}},
+		{http.MethodPost, "/get", http.StatusMethodNotAllowed, []string{"global-1", "global-2"}},
+		{http.MethodGet, "/missing", http.StatusNotFound, []string{"global-1", "global-2"}},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(tt.method, s.URL+tt.path, nil)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.statusCode {
+			t.Errorf("%s %s: expected status code %d, got %d", tt.method, tt.path, tt.statusCode, res.StatusCode)
+		}
+		if order := strings.Join(res.Header.Values("X-Order"), ","); order != strings.Join(tt.order, ",") {
+			t.Errorf("%s %s: expected middlewares to run in order %v, got %s", tt.method, tt.path, tt.order, order)
+		}
+	}
+}
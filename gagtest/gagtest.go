@@ -0,0 +1,242 @@
+// Package gagtest provides utilities for testing Gag routes: an in-process gateway served on a random port,
+// fake upstream servers with scripted responses, and assertions about the requests they received.
+//
+// Example:
+//
+//	func TestRoute(t *testing.T) {
+//		upstream := gagtest.NewUpstream(t).Respond(gagtest.Response{StatusCode: http.StatusOK, Body: `{"id":1}`})
+//
+//		g := gag.NewGag(gag.Config{})
+//		g.Conditions().Path("/users").Method(http.MethodGet).Route(&gag.RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}, g)
+//		gw := gagtest.NewGateway(t, g)
+//
+//		gagtest.AssertResponse(t, gw.Get("/users"), http.StatusOK, `{"id":1}`)
+//		upstream.AssertReceived(http.MethodGet, "/")
+//	}
+package gagtest
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sang-w0o/gag"
+)
+
+// Gateway is a Gag served by an httptest.Server on a random port.
+// It is closed automatically when the test finishes.
+type Gateway struct {
+	*httptest.Server
+	Gag *gag.Gag
+	t   testing.TB
+}
+
+// NewGateway starts serving the Conditions of g on a random port.
+// The test fails immediately if the Conditions are invalid.
+func NewGateway(t testing.TB, g *gag.Gag) *Gateway {
+	t.Helper()
+	h, err := g.Handler()
+	if err != nil {
+		t.Fatalf("gagtest: invalid conditions: %v", err)
+	}
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+	return &Gateway{Server: s, Gag: g, t: t}
+}
+
+// Do sends a request with method, path and body to the gateway.
+// The test fails immediately if the request cannot be sent.
+func (gw *Gateway) Do(method string, path string, body io.Reader, header http.Header) *http.Response {
+	gw.t.Helper()
+	r, err := http.NewRequest(method, gw.URL+path, body)
+	if err != nil {
+		gw.t.Fatalf("gagtest: error creating request: %v", err)
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	res, err := gw.Client().Do(r)
+	if err != nil {
+		gw.t.Fatalf("gagtest: error doing request: %v", err)
+	}
+	return res
+}
+
+// Get sends a GET request for path to the gateway.
+func (gw *Gateway) Get(path string) *http.Response {
+	gw.t.Helper()
+	return gw.Do(http.MethodGet, path, nil, nil)
+}
+
+// Response is a scripted response of an Upstream.
+type Response struct {
+	// StatusCode is the status code of the response. When 0, http.StatusOK will be used.
+	StatusCode int
+	// Header is the header of the response.
+	Header http.Header
+	// Body is the body of the response.
+	Body string
+	// Latency is how long the Upstream waits before responding.
+	Latency time.Duration
+	// Fail closes the connection without responding, simulating a network failure.
+	Fail bool
+}
+
+// RecordedRequest is a request received by an Upstream.
+type RecordedRequest struct {
+	Method   string
+	Path     string
+	RawQuery string
+	Header   http.Header
+	Body     []byte
+}
+
+// Upstream is a fake upstream server, responding with scripted responses and recording the requests it receives.
+// It is closed automatically when the test finishes.
+type Upstream struct {
+	*httptest.Server
+	t        testing.TB
+	mu       sync.Mutex
+	script   []Response
+	fallback Response
+	requests []RecordedRequest
+}
+
+// NewUpstream starts a fake upstream server on a random port,
+// which responds 200 with an empty body until responses are scripted.
+func NewUpstream(t testing.TB) *Upstream {
+	u := &Upstream{t: t}
+	u.Server = httptest.NewServer(http.HandlerFunc(u.serveHTTP))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// Respond scripts responses, which are used in order for the following requests.
+// Once the scripted responses are used up, the response set by Default is used.
+func (u *Upstream) Respond(responses ...Response) *Upstream {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.script = append(u.script, responses...)
+	return u
+}
+
+// Default sets the response used when no scripted response is left.
+func (u *Upstream) Default(res Response) *Upstream {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fallback = res
+	return u
+}
+
+// Requests returns the requests received so far.
+func (u *Upstream) Requests() []RecordedRequest {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]RecordedRequest(nil), u.requests...)
+}
+
+// AssertRequestCount fails the test unless the Upstream received n requests.
+func (u *Upstream) AssertRequestCount(n int) {
+	u.t.Helper()
+	if got := len(u.Requests()); got != n {
+		u.t.Errorf("gagtest: expected upstream to receive %d requests, got %d", n, got)
+	}
+}
+
+// AssertReceived fails the test unless the Upstream received a request with method and path,
+// and returns the first of such requests.
+func (u *Upstream) AssertReceived(method string, path string) RecordedRequest {
+	u.t.Helper()
+	for _, r := range u.Requests() {
+		if r.Method == method && r.Path == path {
+			return r
+		}
+	}
+	u.t.Errorf("gagtest: expected upstream to receive %s %s, got %s", method, path, u.describeRequests())
+	return RecordedRequest{}
+}
+
+// AssertNotReceived fails the test if the Upstream received any request.
+func (u *Upstream) AssertNotReceived() {
+	u.t.Helper()
+	if len(u.Requests()) > 0 {
+		u.t.Errorf("gagtest: expected upstream to receive no request, got %s", u.describeRequests())
+	}
+}
+
+func (u *Upstream) describeRequests() string {
+	requests := u.Requests()
+	if len(requests) == 0 {
+		return "none"
+	}
+	described := make([]string, 0, len(requests))
+	for _, r := range requests {
+		described = append(described, r.Method+" "+r.Path)
+	}
+	return "[" + strings.Join(described, ", ") + "]"
+}
+
+func (u *Upstream) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	u.mu.Lock()
+	u.requests = append(u.requests, RecordedRequest{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		RawQuery: r.URL.RawQuery,
+		Header:   r.Header.Clone(),
+		Body:     body,
+	})
+	res := u.fallback
+	if len(u.script) > 0 {
+		res, u.script = u.script[0], u.script[1:]
+	}
+	u.mu.Unlock()
+
+	if res.Latency > 0 {
+		select {
+		case <-time.After(res.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if res.Fail {
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	statusCode := res.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	io.WriteString(w, res.Body)
+}
+
+// AssertResponse fails the test unless res has statusCode and body. The body of res is closed.
+func AssertResponse(t testing.TB, res *http.Response, statusCode int, body string) {
+	t.Helper()
+	defer res.Body.Close()
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Errorf("gagtest: error reading response body: %v", err)
+		return
+	}
+	if res.StatusCode != statusCode {
+		t.Errorf("gagtest: expected status code %d, got %d", statusCode, res.StatusCode)
+	}
+	if string(got) != body {
+		t.Errorf("gagtest: expected response body %s, got %s", body, string(got))
+	}
+}
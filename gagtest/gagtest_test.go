@@ -0,0 +1,64 @@
+package gagtest_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sang-w0o/gag"
+	"github.com/sang-w0o/gag/gagtest"
+)
+
+func TestGatewayRoutesToUpstream(t *testing.T) {
+	upstream := gagtest.NewUpstream(t).
+		Respond(gagtest.Response{StatusCode: http.StatusCreated, Body: `{"id":1}`}).
+		Default(gagtest.Response{StatusCode: http.StatusOK, Body: `{}`})
+
+	g := gag.NewGag(gag.Config{})
+	g.Conditions().
+		Path("/users").Method(http.MethodPost).Route(&gag.RouteRequest{Url: upstream.URL + "/v1/users", HttpMethod: http.MethodPost, PassRequestBody: true}, g)
+	gw := gagtest.NewGateway(t, g)
+
+	gagtest.AssertResponse(t, gw.Do(http.MethodPost, "/users", strings.NewReader(`{"name":"gag"}`), nil), http.StatusCreated, `{"id":1}`)
+	gagtest.AssertResponse(t, gw.Do(http.MethodPost, "/users", strings.NewReader(`{}`), nil), http.StatusOK, `{}`)
+
+	upstream.AssertRequestCount(2)
+	r := upstream.AssertReceived(http.MethodPost, "/v1/users")
+	if string(r.Body) != `{"name":"gag"}` {
+		t.Errorf("expected upstream to receive body %s, got %s", `{"name":"gag"}`, string(r.Body))
+	}
+}
+
+func TestUpstreamLatencyAndFailureInjection(t *testing.T) {
+	upstream := gagtest.NewUpstream(t).Respond(
+		gagtest.Response{Latency: 500 * time.Millisecond},
+		gagtest.Response{Fail: true},
+	)
+
+	g := gag.NewGag(gag.Config{})
+	g.Conditions().
+		Path("/slow").Route(&gag.RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet, Timeout: 50 * time.Millisecond}, g)
+	gw := gagtest.NewGateway(t, g)
+
+	for i, expected := range []int{http.StatusGatewayTimeout, http.StatusBadGateway} {
+		res := gw.Get("/slow")
+		res.Body.Close()
+		if res.StatusCode != expected {
+			t.Errorf("request %d: expected status code %d, got %d", i, expected, res.StatusCode)
+		}
+	}
+	upstream.AssertRequestCount(2)
+}
+
+func TestUnmatchedRequestDoesNotReachUpstream(t *testing.T) {
+	upstream := gagtest.NewUpstream(t)
+
+	g := gag.NewGag(gag.Config{})
+	g.Conditions().
+		Path("/only-post").Method(http.MethodPost).Route(&gag.RouteRequest{Url: upstream.URL, HttpMethod: http.MethodPost}, g)
+	gw := gagtest.NewGateway(t, g)
+
+	gagtest.AssertResponse(t, gw.Get("/only-post"), http.StatusMethodNotAllowed, "405 method(GET) not allowed")
+	upstream.AssertNotReceived()
+}
@@ -1,5 +1,5 @@
 module github.com/sang-w0o/gag
 
-go 1.17
+go 1.24
 
 require github.com/gorilla/mux v1.8.0
@@ -0,0 +1,165 @@
+package gag
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Group contains properties shared by the Conditions added through it:
+// a path prefix, header matchers, middlewares and RouteRequest defaults.
+// Groups can be nested, in which case the Conditions inherit the properties of all enclosing Groups.
+// Example:
+//  admin := g.Group("/admin").HasHeader("X-Admin-Token").Middlewares(auditMiddleware()).
+//	  RouteDefaults(&gag.RouteRequest{Url: "http://127.0.0.1:8081/admin", Timeout: 2 * time.Second})
+//  admin.Conditions().
+//	  Path("/users").Method(http.MethodGet).Route(&gag.RouteRequest{Url: "/users"}, g).
+//	  Path("/stats").Method(http.MethodGet).HandlerFunc(statsHandler(), g)
+//  admin.Group("/v2").Conditions().
+//	  Path("/users").Method(http.MethodGet).Route(&gag.RouteRequest{Url: "/v2/users"}, g)
+type Group struct {
+	parent        *Group
+	prefix        string
+	header        string
+	headerValue   *headerValue
+	middlewares   []Middleware
+	phases        phasedMiddlewares
+	upstreamHooks []UpstreamHook
+	routeDefaults *RouteRequest
+	ipFilters     []*IPFilter
+}
+
+// Group returns a new Group, whose Conditions have paths starting with prefix.
+func (g *Gag) Group(prefix string) *Group {
+	return &Group{prefix: prefix}
+}
+
+// Group returns a new Group nested in gr, whose Conditions have paths starting with the prefix of gr followed by prefix.
+func (gr *Group) Group(prefix string) *Group {
+	return &Group{parent: gr, prefix: prefix}
+}
+
+// HasHeader requires the requests handled by the Conditions of gr to have the header key same as header.
+// Requests without it are responded with 400, as with Condition.HasHeader().
+func (gr *Group) HasHeader(header string) *Group {
+	gr.header = header
+	return gr
+}
+
+// HasHeaderValue requires the requests handled by the Conditions of gr to have the header key along with value.
+// Requests without it are responded with 400, as with Condition.HasHeaderValue().
+func (gr *Group) HasHeaderValue(key string, value string) *Group {
+	gr.headerValue = &headerValue{key, value}
+	return gr
+}
+
+// Middlewares sets the middlewares applied to the Conditions of gr.
+// They run before the middlewares of the Conditions, and after those of enclosing Groups.
+// As with Condition.Middlewares(), the last middleware runs first.
+func (gr *Group) Middlewares(middlewares ...Middleware) *Group {
+	gr.middlewares = append(([]Middleware)(nil), middlewares...)
+	return gr
+}
+
+// RouteDefaults sets the defaults of the RouteRequests of gr's Conditions.
+// Unset properties of the RouteRequests are set to those of defaults, and a relative Url, such as "/users",
+// is appended to the Url of defaults.
+// PassRequestBody of defaults applies only when it is true.
+func (gr *Group) RouteDefaults(defaults *RouteRequest) *Group {
+	gr.routeDefaults = defaults
+	return gr
+}
+
+// Conditions is used to add Conditions to gr.
+// Conditions following in the builder chain are added to gr as well.
+func (gr *Group) Conditions() *Condition {
+	return &Condition{group: gr}
+}
+
+// apply applies the properties of gr and its enclosing Groups to c, from the innermost Group.
+func (gr *Group) apply(c *Condition) {
+	for group := gr; group != nil; group = group.parent {
+		c.path = group.prefix + c.path
+		c.middlewares.middlewares = append(c.middlewares.middlewares, group.middlewares...)
+		c.phases.inherit(group.phases)
+		c.upstreamHooks = append(append(([]UpstreamHook)(nil), group.upstreamHooks...), c.upstreamHooks...)
+		c.ipFilters = append(append(([]*IPFilter)(nil), group.ipFilters...), c.ipFilters...)
+		if group.headerValue != nil {
+			c.matchers = append([]Middleware{requireHeaderValue(group.headerValue)}, c.matchers...)
+		}
+		if group.header != "" {
+			c.matchers = append([]Middleware{requireHeader(group.header)}, c.matchers...)
+		}
+		if c.routeRequest != nil && group.routeDefaults != nil {
+			c.routeRequest.inherit(group.routeDefaults)
+		}
+	}
+}
+
+// inherit sets the unset properties of rr to those of defaults.
+func (rr *RouteRequest) inherit(defaults *RouteRequest) {
+	if rr.Url == "" || (defaults.Url != "" && !strings.Contains(rr.Url, "://")) {
+		rr.Url = strings.TrimSuffix(defaults.Url, "/") + rr.Url
+	}
+	if rr.HttpMethod == "" {
+		rr.HttpMethod = defaults.HttpMethod
+	}
+	if rr.Timeout == 0 {
+		rr.Timeout = defaults.Timeout
+	}
+	if defaults.PassRequestBody {
+		rr.PassRequestBody = true
+	}
+	if rr.RequestTransform == nil {
+		rr.RequestTransform = defaults.RequestTransform
+	}
+	if rr.ResponseTransform == nil {
+		rr.ResponseTransform = defaults.ResponseTransform
+	}
+	if rr.Mirror == nil {
+		rr.Mirror = defaults.Mirror
+	}
+	if rr.Split == nil {
+		rr.Split = defaults.Split
+	}
+	if rr.Hedge == nil {
+		rr.Hedge = defaults.Hedge
+	}
+	if rr.Discovery == nil {
+		rr.Discovery = defaults.Discovery
+	}
+	if rr.Cache == nil {
+		rr.Cache = defaults.Cache
+	}
+	if rr.UpgradeIdleTimeout == 0 {
+		rr.UpgradeIdleTimeout = defaults.UpgradeIdleTimeout
+	}
+	if rr.CircuitBreaker == nil {
+		rr.CircuitBreaker = defaults.CircuitBreaker
+	}
+}
+
+// requireHeader returns a Middleware responding 400 to requests without header.
+func requireHeader(header string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Header[http.CanonicalHeaderKey(header)]; !ok {
+				respond400BadHeader(w, r, header)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireHeaderValue returns a Middleware responding 400 to requests without the header key along with value.
+func requireHeaderValue(hv *headerValue) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasHeaderValue(hv.Value, r.Header[http.CanonicalHeaderKey(hv.Key)]) {
+				respond400BadHeaderValue(w, r, hv)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
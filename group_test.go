@@ -0,0 +1,59 @@
+package gag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupInheritsProperties(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.Method + " " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	g := NewGag(Config{})
+	admin := g.Group("/admin").HasHeader("X-Admin").Middlewares(orderMiddleware("admin")).
+		RouteDefaults(&RouteRequest{Url: upstream.URL + "/internal", HttpMethod: http.MethodGet, Timeout: time.Second})
+	admin.Conditions().
+		Path("/users").Middlewares(orderMiddleware("condition")).Route(&RouteRequest{Url: "/users"}, g).
+		Path("/stats").HandlerFunc(sampleHandler(), g)
+	admin.Group("/v2").HasHeaderValue("X-Version", "2").Middlewares(orderMiddleware("v2")).Conditions().
+		Path("/users").Route(&RouteRequest{Url: "/v2/users", HttpMethod: http.MethodPost}, g)
+	g.Conditions().Path("/public").HandlerFunc(sampleHandler(), g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		path       string
+		header     map[string]string
+		statusCode int
+		body       string
+		order      []string
+	}{
+		{"/admin/users", map[string]string{"X-Admin": "1"}, http.StatusOK, "GET /internal/users", []string{"admin", "condition"}},
+		{"/admin/users", nil, http.StatusBadRequest, "400 header(X-Admin) not provided", nil},
+		{"/admin/v2/users", map[string]string{"X-Admin": "1", "X-Version": "2"}, http.StatusOK, "POST /internal/v2/users", []string{"admin", "v2"}},
+		{"/admin/v2/users", map[string]string{"X-Admin": "1"}, http.StatusBadRequest, "400 header(X-Version) with value(2) not provided", nil},
+		{"/public", nil, http.StatusOK, `{"message":"sample handler!"}`, nil},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, s.URL+tt.path, nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		if order := strings.Join(res.Header.Values("X-Order"), ","); order != strings.Join(tt.order, ",") {
+			t.Errorf("%s: expected middlewares to run in order %v, got %s", tt.path, tt.order, order)
+		}
+		if err := validateResponse(res, tt.statusCode, tt.body); err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package gag
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GrpcRoute contains all properties about where and how gRPC requests will be proxied.
+// Configure GrpcRoute using Condition.Grpc() method.
+type GrpcRoute struct {
+	// Url is the base url of the gRPC backend, such as "http://127.0.0.1:50051".
+	// Requests are sent with HTTP/2 in cleartext(h2c) for http scheme, and with HTTP/2 over TLS for https scheme.
+	// The path of the request, such as "/helloworld.Greeter/SayHello", is appended to the Url.
+	Url string
+	// Timeout is the timeout value of each call. When 0, the deadline propagated
+	// by the grpc-timeout header of the client is the only limit.
+	Timeout time.Duration
+	// GrpcWeb determines whether gRPC-Web requests from browsers are translated into native gRPC calls.
+	GrpcWeb bool
+
+	once      sync.Once
+	transport *http.Transport
+	base      *url.URL
+	err       error
+}
+
+// gRPC status codes written by Gag.
+const (
+	grpcStatusUnavailable      = 14
+	grpcStatusDeadlineExceeded = 4
+	grpcStatusInternal         = 13
+)
+
+// grpcMessages are the messages written along with the gRPC status codes written by Gag.
+var grpcMessages = map[int]string{
+	grpcStatusUnavailable:      "upstream unavailable",
+	grpcStatusDeadlineExceeded: "upstream timed out",
+	grpcStatusInternal:         "internal error",
+}
+
+// grpcWebTrailerFlag marks the frame containing trailers in a gRPC-Web response.
+const grpcWebTrailerFlag = 0x80
+
+// hopHeaders are the connection-specific headers which must not be relayed.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func isGrpcRequest(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+")
+}
+
+func isGrpcWebRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc-web")
+}
+
+func (gr *GrpcRoute) init() error {
+	gr.once.Do(func() {
+		gr.base, gr.err = url.Parse(gr.Url)
+		if gr.err != nil {
+			return
+		}
+		protocols := new(http.Protocols)
+		switch gr.base.Scheme {
+		case "http":
+			protocols.SetUnencryptedHTTP2(true)
+		case "https":
+			protocols.SetHTTP2(true)
+		default:
+			gr.err = fmt.Errorf("unsupported scheme(%s) for gRPC", gr.base.Scheme)
+			return
+		}
+		gr.transport = &http.Transport{Protocols: protocols, ForceAttemptHTTP2: true}
+	})
+	return gr.err
+}
+
+func (gr *GrpcRoute) handlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := gr.init(); err != nil {
+			writeGrpcError(w, r, grpcStatusInternal, err)
+			return
+		}
+		switch {
+		case isGrpcRequest(r):
+			gr.proxy(w, r)
+		case gr.GrpcWeb && isGrpcWebRequest(r):
+			gr.proxyGrpcWeb(w, r)
+		default:
+			respond415(w, r)
+		}
+	}
+}
+
+// proxy relays the native gRPC request r to the backend, streaming both bodies and relaying trailers.
+func (gr *GrpcRoute) proxy(w http.ResponseWriter, r *http.Request) {
+	resp, cancel, err := gr.roundTrip(r, r.Body, r.Header.Get("Content-Type"))
+	if err != nil {
+		writeGrpcError(w, r, grpcStatusOf(err), err)
+		return
+	}
+	defer cancel()
+	defer resp.Body.Close()
+
+	copyHeader(w.Header(), resp.Header)
+	for k := range resp.Trailer {
+		w.Header().Add("Trailer", k)
+	}
+	w.WriteHeader(resp.StatusCode)
+	flushCopy(w, resp.Body, w)
+	// Backends such as grpc-go send grpc-status and grpc-message without announcing them,
+	// so every trailer is written with http.TrailerPrefix rather than only those announced.
+	for k, v := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+k] = v
+	}
+}
+
+// proxyGrpcWeb translates the gRPC-Web request r into a native gRPC call,
+// and writes the trailers of the backend as the last frame of the response body.
+func (gr *GrpcRoute) proxyGrpcWeb(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, "application/grpc-web-text")
+	grpcContentType := "application/grpc" + strings.TrimPrefix(strings.TrimPrefix(contentType, "application/grpc-web-text"), "application/grpc-web")
+
+	var body io.Reader = r.Body
+	if text {
+		body = base64.NewDecoder(base64.StdEncoding, r.Body)
+	}
+	resp, cancel, err := gr.roundTrip(r, body, grpcContentType)
+	if err != nil {
+		writeGrpcError(w, r, grpcStatusOf(err), err)
+		return
+	}
+	defer cancel()
+	defer resp.Body.Close()
+
+	copyHeader(w.Header(), resp.Header)
+	w.Header().Set("Content-Type", strings.Replace(resp.Header.Get("Content-Type"), "application/grpc", strings.SplitN(contentType, "+", 2)[0], 1))
+	w.Header().Del("Content-Length")
+	w.WriteHeader(resp.StatusCode)
+
+	var out io.Writer = w
+	var encoder io.WriteCloser
+	if text {
+		encoder = base64.NewEncoder(base64.StdEncoding, w)
+		out = encoder
+	}
+	flushCopy(out, resp.Body, w)
+	if len(resp.Trailer) > 0 {
+		out.Write(grpcWebTrailerFrame(resp.Trailer))
+	}
+	if encoder != nil {
+		encoder.Close()
+	}
+}
+
+// roundTrip sends the gRPC call r with body to the backend.
+// The returned cancel function should be called after the response body is read.
+func (gr *GrpcRoute) roundTrip(r *http.Request, body io.Reader, contentType string) (*http.Response, context.CancelFunc, error) {
+	ctx, cancel := r.Context(), context.CancelFunc(func() {})
+	if gr.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, gr.Timeout)
+	}
+
+	target := *gr.base
+	target.Path = strings.TrimSuffix(gr.base.Path, "/") + r.URL.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), body)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	copyHeader(req.Header, r.Header)
+	for k := range req.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-grpc-web") {
+			req.Header.Del(k)
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Te", "trailers")
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+
+	resp, err := gr.transport.RoundTrip(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return resp, cancel, nil
+}
+
+// grpcWebTrailerFrame encodes trailer as a gRPC-Web trailer frame.
+func grpcWebTrailerFrame(trailer http.Header) []byte {
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var payload bytes.Buffer
+	for _, k := range keys {
+		for _, v := range trailer[k] {
+			fmt.Fprintf(&payload, "%s: %s\r\n", strings.ToLower(k), v)
+		}
+	}
+	frame := make([]byte, 5, 5+payload.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(payload.Len()))
+	return append(frame, payload.Bytes()...)
+}
+
+// grpcStatusOf returns the gRPC status code for err, which occurred while calling the backend.
+func grpcStatusOf(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return grpcStatusDeadlineExceeded
+	}
+	return grpcStatusUnavailable
+}
+
+// writeGrpcError writes a trailers-only gRPC response with code, which gRPC and gRPC-Web clients both understand.
+// err is only logged, since it may contain internal details of the backend.
+func writeGrpcError(w http.ResponseWriter, r *http.Request, code int, err error) {
+	logger{}.Println(fmt.Sprintf("%s %s: %s", r.Method, r.URL.Path, err.Error()))
+	w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+	w.Header().Set("Grpc-Status", fmt.Sprint(code))
+	w.Header().Set("Grpc-Message", url.PathEscape(grpcMessages[code]))
+	w.WriteHeader(http.StatusOK)
+}
+
+// copyHeader copies src into dst, except for hop-by-hop headers.
+func copyHeader(dst http.Header, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+	for _, k := range hopHeaders {
+		dst.Del(k)
+	}
+}
+
+// flushCopy copies src to dst, flushing w after each write so that streamed messages are not delayed.
+func flushCopy(dst io.Writer, src io.Reader, w http.ResponseWriter) error {
+	f, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if f != nil {
+				f.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package gag
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func grpcFrame(flag byte, payload string) []byte {
+	frame := make([]byte, 5)
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// newGrpcBackend returns an h2c backend which answers each call with the upper-cased request message.
+// Unless announceTrailers, the grpc-status trailer is sent without being announced by the Trailer header, as grpc-go does.
+func newGrpcBackend(t *testing.T, announceTrailers bool) *httptest.Server {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("expected HTTP/2 call, got %s", r.Proto)
+		}
+		if r.Header.Get("Content-Type") != "application/grpc+proto" {
+			t.Errorf("expected content type %s, got %s", "application/grpc+proto", r.Header.Get("Content-Type"))
+		}
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(r.Body, header); err != nil {
+			t.Errorf("error reading message header: %v", err)
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(r.Body, payload); err != nil {
+			t.Errorf("error reading message: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		if announceTrailers {
+			w.Header().Set("Trailer", "Grpc-Status")
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(grpcFrame(0, strings.ToUpper(string(payload))))
+		if announceTrailers {
+			w.Header().Set("Grpc-Status", "0")
+		} else {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		}
+	}))
+	s.Config.Protocols = new(http.Protocols)
+	s.Config.Protocols.SetHTTP1(true)
+	s.Config.Protocols.SetUnencryptedHTTP2(true)
+	s.Start()
+	return s
+}
+
+func newGrpcGag(backendURL string) *Gag {
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/echo.Echo/{method}").Method(http.MethodPost).Grpc(&GrpcRoute{Url: backendURL, GrpcWeb: true}, g)
+	return g
+}
+
+func TestGrpcProxy(t *testing.T) {
+	for _, announceTrailers := range []bool{true, false} {
+		testGrpcProxy(t, announceTrailers)
+	}
+}
+
+func testGrpcProxy(t *testing.T, announceTrailers bool) {
+	backend := newGrpcBackend(t, announceTrailers)
+	defer backend.Close()
+
+	s := httptest.NewUnstartedServer(nil)
+	g := newGrpcGag(backend.URL)
+	g.configureHandler()
+	s.Config.Handler = http.HandlerFunc(g.serveHTTP)
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	defer s.Close()
+
+	r, err := http.NewRequest(http.MethodPost, s.URL+"/echo.Echo/Say", bytes.NewReader(grpcFrame(0, "hi")))
+	if err != nil {
+		t.Errorf("error creating request: %v", err)
+		return
+	}
+	r.Header.Set("Content-Type", "application/grpc+proto")
+	res, err := s.Client().Do(r)
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	if err := validateResponse(res, http.StatusOK, string(grpcFrame(0, "HI"))); err != nil {
+		t.Error(err)
+		return
+	}
+	if res.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("announced(%t): expected trailer grpc-status %s, got %s", announceTrailers, "0", res.Trailer.Get("Grpc-Status"))
+	}
+}
+
+func TestGrpcWebProxy(t *testing.T) {
+	for _, announceTrailers := range []bool{true, false} {
+		testGrpcWebProxy(t, announceTrailers)
+	}
+}
+
+func testGrpcWebProxy(t *testing.T, announceTrailers bool) {
+	backend := newGrpcBackend(t, announceTrailers)
+	defer backend.Close()
+	s := newTestServer(newGrpcGag(backend.URL))
+	defer s.Close()
+
+	expected := string(grpcFrame(0, "HI")) + string(grpcFrame(grpcWebTrailerFlag, "grpc-status: 0\r\n"))
+	tests := []struct {
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"application/grpc-web+proto", string(grpcFrame(0, "hi")), expected},
+		{"application/grpc-web-text+proto", base64.StdEncoding.EncodeToString(grpcFrame(0, "hi")), base64.StdEncoding.EncodeToString([]byte(expected))},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest(http.MethodPost, s.URL+"/echo.Echo/Say", strings.NewReader(tt.body))
+		if err != nil {
+			t.Errorf("error creating request: %v", err)
+			return
+		}
+		r.Header.Set("Content-Type", tt.contentType)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("error doing request: %v", err)
+			return
+		}
+		if res.Header.Get("Content-Type") != tt.contentType {
+			t.Errorf("expected content type %s, got %s", tt.contentType, res.Header.Get("Content-Type"))
+		}
+		if err := validateResponse(res, http.StatusOK, tt.expected); err != nil {
+			t.Errorf("%s: %v", tt.contentType, err)
+		}
+	}
+}
+
+func TestGrpcBackendUnavailable(t *testing.T) {
+	backend := newGrpcBackend(t, true)
+	backend.Close()
+	s := newTestServer(newGrpcGag(backend.URL))
+	defer s.Close()
+
+	r, err := http.NewRequest(http.MethodPost, s.URL+"/echo.Echo/Say", bytes.NewReader(grpcFrame(0, "hi")))
+	if err != nil {
+		t.Errorf("error creating request: %v", err)
+		return
+	}
+	r.Header.Set("Content-Type", "application/grpc-web+proto")
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+
+	if res.Header.Get("Grpc-Status") != "14" {
+		t.Errorf("expected grpc-status %s, got %s", "14", res.Header.Get("Grpc-Status"))
+	}
+}
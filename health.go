@@ -0,0 +1,161 @@
+package gag
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	gorillaMux "github.com/gorilla/mux"
+)
+
+// Default paths of the health endpoints.
+const (
+	DefaultLivenessPath  = "/healthz"
+	DefaultReadinessPath = "/readyz"
+)
+
+// HealthConfig contains properties about the health endpoints of Gag itself.
+// The endpoints are registered before all Conditions, so they take precedence over Conditions having the same path.
+type HealthConfig struct {
+	// LivenessPath is the path of the liveness endpoint, which responds 200 while Gag is serving.
+	// When "", DefaultLivenessPath will be used.
+	LivenessPath string
+	// ReadinessPath is the path of the readiness endpoint, which responds 200 when Gag is ready to handle requests,
+	// and 503 otherwise. When "", DefaultReadinessPath will be used.
+	ReadinessPath string
+	// CheckUpstreams determines whether readiness depends on the circuits of RouteRequest.CircuitBreaker,
+	// so that Gag is not ready when the circuits of UnhealthyUpstreamRatio of the RouteRequests are open.
+	// A circuit is half-open once its open duration has passed, so that Gag becomes ready again
+	// even if no request is routed to the upstream while it is not ready.
+	CheckUpstreams bool
+	// UnhealthyUpstreamRatio is the ratio of the RouteRequests having open circuits to all RouteRequests,
+	// from which Gag is not ready when CheckUpstreams is true, such as 0.5 for half of them.
+	// When 0, 1 will be used, so that a single failing upstream doesn't take the whole Gag out of service.
+	UnhealthyUpstreamRatio float64
+	// ShutdownDelay is how long Gag keeps serving after readiness flipped to not-ready on Gag.Shutdown(),
+	// so that load balancers stop sending requests before the listener is closed.
+	ShutdownDelay time.Duration
+}
+
+// readiness contains the states which Gag's readiness depends on.
+type readiness struct {
+	listening    atomic.Bool
+	configLoaded atomic.Bool
+	shuttingDown atomic.Bool
+}
+
+// Readiness checks reported by the readiness endpoint.
+const (
+	checkListener  = "listener"
+	checkConfig    = "config"
+	checkUpstreams = "upstreams"
+	checkShutdown  = "shutdown"
+	checkOK        = "ok"
+)
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (g *Gag) registerHealthHandlers(mux *gorillaMux.Router) {
+	livenessPath, readinessPath := g.cfg.Health.LivenessPath, g.cfg.Health.ReadinessPath
+	if livenessPath == "" {
+		livenessPath = DefaultLivenessPath
+	}
+	if readinessPath == "" {
+		readinessPath = DefaultReadinessPath
+	}
+	mux.HandleFunc(livenessPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, healthResponse{Status: checkOK})
+	})
+	mux.HandleFunc(readinessPath, g.handleReadiness)
+}
+
+func (g *Gag) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		checkListener:  checkOK,
+		checkConfig:    checkOK,
+		checkShutdown:  checkOK,
+		checkUpstreams: checkOK,
+	}
+	if !g.readiness.listening.Load() {
+		checks[checkListener] = "not listening"
+	}
+	if !g.readiness.configLoaded.Load() {
+		checks[checkConfig] = "not loaded"
+	}
+	if g.readiness.shuttingDown.Load() {
+		checks[checkShutdown] = "shutting down"
+	}
+	if g.cfg.Health.CheckUpstreams {
+		ratio := g.cfg.Health.UnhealthyUpstreamRatio
+		if ratio == 0 {
+			ratio = 1
+		}
+		if unhealthy, total := g.unhealthyUpstreams(); unhealthy > 0 && float64(unhealthy) >= ratio*float64(total) {
+			checks[checkUpstreams] = fmt.Sprintf("%d unhealthy", unhealthy)
+		}
+	} else {
+		delete(checks, checkUpstreams)
+	}
+
+	for _, result := range checks {
+		if result != checkOK {
+			writeJSON(w, r, http.StatusServiceUnavailable, healthResponse{Status: "not ready", Checks: checks})
+			return
+		}
+	}
+	writeJSON(w, r, http.StatusOK, healthResponse{Status: "ready", Checks: checks})
+}
+
+// unhealthyUpstreams returns the number of the RouteRequests having open circuits, and the number of all RouteRequests.
+func (g *Gag) unhealthyUpstreams() (int, int) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	unhealthy := 0
+	seen := map[*RouteRequest]bool{}
+	for _, c := range g.conditions {
+		if c.routeRequest == nil || c.disabled || seen[c.routeRequest] {
+			continue
+		}
+		seen[c.routeRequest] = true
+		if c.routeRequest.Health().Circuit == CircuitOpen {
+			unhealthy++
+		}
+	}
+	return unhealthy, len(seen)
+}
+
+// Shutdown gracefully shuts down Gag.
+// Readiness flips to not-ready first, and after Config.Health.ShutdownDelay,
+// the listeners are closed and in-flight requests are waited for until ctx is done.
+// Serve returns nil once Shutdown is called.
+func (g *Gag) Shutdown(ctx context.Context) error {
+	g.readiness.shuttingDown.Store(true)
+	if g.cfg.Health != nil && g.cfg.Health.ShutdownDelay > 0 {
+		g.log.Println(fmt.Sprintf("gag shutting down in %v", g.cfg.Health.ShutdownDelay))
+		select {
+		case <-time.After(g.cfg.Health.ShutdownDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	g.mu.RLock()
+	s, admin := g.s, g.admin
+	g.mu.RUnlock()
+	var err error
+	if s != nil {
+		err = s.Shutdown(ctx)
+	}
+	if admin != nil {
+		if adminErr := admin.Shutdown(ctx); err == nil {
+			err = adminErr
+		}
+	}
+	g.log.Println("gag shut down")
+	return err
+}
@@ -0,0 +1,174 @@
+package gag
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getHealth(t *testing.T, url string) (int, healthResponse) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	var body healthResponse
+	decodeJSON(t, res, &body)
+	return res.StatusCode, body
+}
+
+func TestReadinessBeforeServe(t *testing.T) {
+	g := NewGag(Config{Health: &HealthConfig{}})
+	g.configureHandler()
+	s := httptest.NewServer(http.HandlerFunc(g.serveHTTP))
+	defer s.Close()
+
+	statusCode, body := getHealth(t, s.URL+DefaultReadinessPath)
+	if statusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status code %d, got %d", http.StatusServiceUnavailable, statusCode)
+	}
+	if body.Checks[checkListener] == checkOK || body.Checks[checkConfig] == checkOK {
+		t.Errorf("expected listener and config checks to fail, got %+v", body.Checks)
+	}
+
+	statusCode, _ = getHealth(t, s.URL+DefaultLivenessPath)
+	if statusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, statusCode)
+	}
+}
+
+func TestReadinessFlipsDuringShutdown(t *testing.T) {
+	g := NewGag(Config{Health: &HealthConfig{ReadinessPath: "/ready", ShutdownDelay: 300 * time.Millisecond}})
+	g.Conditions().Path("/a").HandlerFunc(sampleHandler(), g)
+	served := make(chan error, 1)
+	go func() {
+		served <- g.Serve()
+	}()
+	for i := 0; i < 100 && !g.readiness.listening.Load(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	url := fmt.Sprintf("http://localhost:%d/ready", g.port)
+
+	if statusCode, body := getHealth(t, url); statusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d: %+v", http.StatusOK, statusCode, body)
+		return
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- g.Shutdown(context.Background())
+	}()
+	for i := 0; i < 10 && !g.readiness.shuttingDown.Load(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	statusCode, body := getHealth(t, url)
+	if statusCode != http.StatusServiceUnavailable || body.Checks[checkShutdown] == checkOK {
+		t.Errorf("expected not ready while shutting down, got %d: %+v", statusCode, body)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("error shutting down: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("expected Serve to return nil after Shutdown, got %v", err)
+	}
+}
+
+func TestReadinessChecksUpstreams(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{}`))
+	upstream.Close()
+
+	g := NewGag(Config{Health: &HealthConfig{CheckUpstreams: true}})
+	g.Conditions().Path("/down").Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet, CircuitBreaker: &CircuitBreaker{FailureThreshold: 1}}, g)
+	g.readiness.listening.Store(true)
+	g.readiness.configLoaded.Store(true)
+	s := newTestServer(g)
+	defer s.Close()
+
+	if statusCode, body := getHealth(t, s.URL+DefaultReadinessPath); statusCode != http.StatusOK {
+		t.Errorf("expected status code %d before any failure, got %d: %+v", http.StatusOK, statusCode, body)
+	}
+
+	res, err := http.Get(s.URL + "/down")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	res.Body.Close()
+
+	statusCode, body := getHealth(t, s.URL+DefaultReadinessPath)
+	if statusCode != http.StatusServiceUnavailable || body.Checks[checkUpstreams] != "1 unhealthy" {
+		t.Errorf("expected not ready with an unhealthy upstream, got %d: %+v", statusCode, body)
+	}
+}
+
+func TestReadinessRecoversAfterCircuitOpenDuration(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{}`))
+	upstream.Close()
+
+	g := NewGag(Config{Health: &HealthConfig{CheckUpstreams: true}})
+	g.Conditions().Path("/down").Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet, CircuitBreaker: &CircuitBreaker{FailureThreshold: 1, OpenDuration: 100 * time.Millisecond}}, g)
+	g.readiness.listening.Store(true)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/down")
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	res.Body.Close()
+	if statusCode, body := getHealth(t, s.URL+DefaultReadinessPath); statusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready while the circuit is open, got %d: %+v", statusCode, body)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if statusCode, body := getHealth(t, s.URL+DefaultReadinessPath); statusCode != http.StatusOK {
+		t.Errorf("expected ready after the circuit open duration without further requests, got %d: %+v", statusCode, body)
+	}
+}
+
+func TestReadinessOfHandler(t *testing.T) {
+	g := NewGag(Config{Health: &HealthConfig{}})
+	g.Conditions().Path("/a").HandlerFunc(sampleHandler(), g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	if statusCode, body := getHealth(t, s.URL+DefaultReadinessPath); statusCode != http.StatusOK {
+		t.Errorf("expected ready when served by another server, got %d: %+v", statusCode, body)
+	}
+}
+
+func TestReadinessUnhealthyUpstreamRatio(t *testing.T) {
+	up := httptest.NewServer(jsonHandler(`{}`))
+	defer up.Close()
+	down := httptest.NewServer(jsonHandler(`{}`))
+	down.Close()
+
+	tests := []struct {
+		ratio      float64
+		statusCode int
+	}{
+		{0, http.StatusOK},
+		{0.5, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		g := NewGag(Config{Health: &HealthConfig{CheckUpstreams: true, UnhealthyUpstreamRatio: tt.ratio}})
+		cb := &CircuitBreaker{FailureThreshold: 1}
+		g.Conditions().
+			Path("/up").Route(&RouteRequest{Url: up.URL, HttpMethod: http.MethodGet, CircuitBreaker: cb}, g).
+			Path("/down").Route(&RouteRequest{Url: down.URL, HttpMethod: http.MethodGet, CircuitBreaker: cb}, g)
+		s := newTestServer(g)
+
+		res, err := http.Get(s.URL + "/down")
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		res.Body.Close()
+		if statusCode, body := getHealth(t, s.URL+DefaultReadinessPath); statusCode != tt.statusCode {
+			t.Errorf("ratio %v: expected status code %d with 1 of 2 circuits open, got %d: %+v", tt.ratio, tt.statusCode, statusCode, body)
+		}
+		s.Close()
+	}
+}
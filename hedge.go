@@ -0,0 +1,197 @@
+package gag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hedgeSamples is the number of recent latencies which Hedge.Percentile is computed from.
+const hedgeSamples = 100
+
+// hedgeMinSamples is the number of latencies to observe before Hedge.Percentile is used instead of Hedge.Delay.
+const hedgeMinSamples = 20
+
+// Hedge contains all properties about how requests routed to RouteRequest.Url are hedged:
+// when the upstream hasn't responded within a delay, a second request is sent,
+// the first successful response is used, and the other request is canceled.
+// Hedging duplicates requests, so only routes with an idempotent RouteRequest.HttpMethod can be hedged.
+// Configure Hedge by setting RouteRequest.Hedge.
+type Hedge struct {
+	// Url is the url of the upstream which the second request is sent to, such as another replica.
+	// When "", the second request is sent to the same url as the first, or to the next endpoint found by RouteRequest.Discovery.
+	Url string
+	// Delay is how long to wait for the first response before sending the second request, and must be positive.
+	// When Percentile is set, Delay is used until enough latencies are observed.
+	Delay time.Duration
+	// Percentile, such as 95, makes the delay the given percentile of the latencies observed from recent successful responses.
+	// When 0, Delay is always used.
+	Percentile float64
+
+	stats     hedgeCounters
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+// HedgeStats are the metrics of hedged requests.
+type HedgeStats struct {
+	// Hedged is the number of second requests sent.
+	Hedged int64 `json:"hedged"`
+	// Won is the number of second requests whose response was used.
+	Won int64 `json:"won"`
+	// Delay is the current delay before sending a second request.
+	Delay time.Duration `json:"delay"`
+}
+
+type hedgeCounters struct {
+	hedged int64
+	won    int64
+}
+
+// hedgeResult is the outcome of a request sent by Hedge.
+type hedgeResult struct {
+	res    *UpstreamResponse
+	err    error
+	hedged bool
+}
+
+// Stats returns the metrics of the requests hedged by h.
+func (h *Hedge) Stats() HedgeStats {
+	return HedgeStats{
+		Hedged: atomic.LoadInt64(&h.stats.hedged),
+		Won:    atomic.LoadInt64(&h.stats.won),
+		Delay:  h.delay(),
+	}
+}
+
+func (h *Hedge) validate(httpMethod string) error {
+	if h.Url != "" {
+		if err := validateUpstreamUrl(h.Url, "http", "https"); err != nil {
+			return fmt.Errorf("hedge url(%s) is invalid: %s", h.Url, err.Error())
+		}
+	}
+	if !isIdempotentMethod(httpMethod) {
+		return fmt.Errorf("hedge requires an idempotent route method, not %s", httpMethod)
+	}
+	// A delay of 0 would send every request twice.
+	if h.Delay <= 0 {
+		return fmt.Errorf("hedge delay(%v) must be positive", h.Delay)
+	}
+	if h.Percentile < 0 || h.Percentile > 100 {
+		return fmt.Errorf("hedge percentile(%v) must be between 0 and 100", h.Percentile)
+	}
+	return nil
+}
+
+// isIdempotentMethod reports whether sending a request of method more than once has the same effect as sending it once.
+// "" is the same as http.MethodGet.
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// delay returns how long to wait for the first response before sending the second request.
+func (h *Hedge) delay() time.Duration {
+	if h.Percentile == 0 {
+		return h.Delay
+	}
+	h.mu.Lock()
+	latencies := slices.Clone(h.latencies)
+	h.mu.Unlock()
+	if len(latencies) < hedgeMinSamples {
+		return h.Delay
+	}
+	slices.Sort(latencies)
+	i := int(float64(len(latencies)-1) * h.Percentile / 100)
+	return latencies[i]
+}
+
+// observe records the latency of a successful response.
+func (h *Hedge) observe(latency time.Duration) {
+	if h.Percentile == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < hedgeSamples {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % hedgeSamples
+}
+
+// do sends req by rr, and sends a second request if no response is received within h.delay().
+// body is the request body, which is sent again by the second request.
+// target is the url of rr which req is sent to, before the host is replaced by a discovered endpoint.
+// The first successful response is returned, or the last failure if both requests fail.
+func (h *Hedge) do(rr *RouteRequest, req *http.Request, body []byte, target string) (*UpstreamResponse, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	results := make(chan hedgeResult, 2)
+	send := func(req *http.Request, hedged bool) {
+		start := time.Now()
+		res, err := rr.send(req)
+		if err == nil && res.StatusCode < 500 {
+			h.observe(time.Since(start))
+		}
+		results <- hedgeResult{res: res, err: err, hedged: hedged}
+	}
+	go send(req.WithContext(ctx), false)
+
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			second, err := h.request(ctx, req, body, rr.endpoint(target))
+			if err != nil {
+				continue
+			}
+			atomic.AddInt64(&h.stats.hedged, 1)
+			pending++
+			go send(second, true)
+		case result := <-results:
+			pending--
+			if result.err == nil && result.res.StatusCode < 500 {
+				if result.hedged {
+					atomic.AddInt64(&h.stats.won, 1)
+				}
+				return result.res, nil
+			}
+			// A failure before the delay is not hedged, since the upstream responded without being slow.
+			if pending == 0 {
+				return result.res, result.err
+			}
+		}
+	}
+}
+
+// request returns the second request, which is the same as req but sent to h.Url,
+// or to target, which is another endpoint if the endpoints of the route are discovered.
+func (h *Hedge) request(ctx context.Context, req *http.Request, body []byte, target string) (*http.Request, error) {
+	if h.Url != "" {
+		target = h.Url
+	}
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	second, err := http.NewRequestWithContext(ctx, req.Method, target, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	second.Header = req.Header.Clone()
+	return second, nil
+}
@@ -0,0 +1,110 @@
+package gag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHedgeUsesFirstSuccessfulResponse(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		case <-time.After(2 * time.Second):
+		}
+		w.Write([]byte(`{"from":"slow"}`))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(jsonHandler(`{"from":"fast"}`))
+	defer fast.Close()
+
+	hedge := &Hedge{Url: fast.URL, Delay: 50 * time.Millisecond}
+	rr := &RouteRequest{Url: slow.URL, HttpMethod: http.MethodGet, Hedge: hedge}
+	g := NewGag(Config{})
+	g.Conditions().Path("/items").Route(rr, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	start := time.Now()
+	res, err := http.Get(s.URL + "/items")
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	if err := validateResponse(res, http.StatusOK, `{"from":"fast"}`); err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected hedged response before the slow upstream, took %v", elapsed)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("expected the slow request to be canceled")
+	}
+	if stats := hedge.Stats(); stats.Hedged != 1 || stats.Won != 1 {
+		t.Errorf("unexpected hedge stats: %+v", stats)
+	}
+	if health := rr.Health(); !health.Healthy {
+		t.Errorf("expected canceled request not to be a failure, got %+v", health)
+	}
+}
+
+func TestHedgeNotSentForFastResponses(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{}`))
+	defer upstream.Close()
+
+	hedge := &Hedge{Delay: time.Second}
+	g := NewGag(Config{})
+	g.Conditions().Path("/items").Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet, Hedge: hedge}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/items")
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	if err := validateResponse(res, http.StatusOK, `{}`); err != nil {
+		t.Error(err)
+	}
+	if stats := hedge.Stats(); stats.Hedged != 0 {
+		t.Errorf("expected no hedged requests, got %+v", stats)
+	}
+}
+
+func TestHedgeDelayPercentile(t *testing.T) {
+	hedge := &Hedge{Delay: time.Second, Percentile: 95}
+	for i := 1; i < hedgeMinSamples; i++ {
+		hedge.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := hedge.delay(); d != time.Second {
+		t.Errorf("expected Delay before enough latencies are observed, got %v", d)
+	}
+	for i := hedgeMinSamples; i <= hedgeSamples; i++ {
+		hedge.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := hedge.delay(); d != 95*time.Millisecond {
+		t.Errorf("expected p95 of observed latencies, got %v", d)
+	}
+}
+
+func TestHedgeValidation(t *testing.T) {
+	tests := []struct {
+		hedge  *Hedge
+		reason string
+	}{
+		{&Hedge{}, "hedge delay(0s) must be positive"},
+		{&Hedge{Percentile: 95}, "hedge delay(0s) must be positive"},
+		{&Hedge{Delay: -time.Second}, "hedge delay(-1s) must be positive"},
+		{&Hedge{Delay: time.Second, Percentile: 101}, "hedge percentile(101) must be between 0 and 100"},
+		{&Hedge{Delay: time.Second, Percentile: 95}, ""},
+	}
+	for _, tt := range tests {
+		err := tt.hedge.validate(http.MethodGet)
+		if (tt.reason == "" && err != nil) || (tt.reason != "" && (err == nil || err.Error() != tt.reason)) {
+			t.Errorf("%+v: expected %q, got %v", tt.hedge, tt.reason, err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package gag
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// IPFilter allows or denies requests by the IP address of the client, resolved as described by ClientIP.
+// Entries of Allow and Deny are CIDRs, such as "10.0.0.0/8", or single addresses, such as "192.0.2.1".
+// Requests denied are responded with 403.
+// Configure IPFilter gateway-wide by setting Config.IPFilter, or per path with Condition.IPFilter() and Group.IPFilter().
+type IPFilter struct {
+	// Allow lists the addresses allowed. When empty, all addresses not in Deny are allowed.
+	Allow []string
+	// Deny lists the addresses denied, even if they are in Allow.
+	Deny []string
+
+	once  sync.Once
+	allow ipSet
+	deny  ipSet
+}
+
+// ipSet is a set of IP addresses.
+type ipSet []netip.Prefix
+
+// clientIPKey is the context key of the IP address of the client.
+type clientIPKey struct{}
+
+// parseIPSet parses entries, each of which is a CIDR or a single address.
+func parseIPSet(entries []string) (ipSet, error) {
+	set := make(ipSet, 0, len(entries))
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("CIDR(%s) is invalid: %s", entry, err.Error())
+			}
+			set = append(set, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("ip(%s) is invalid: %s", entry, err.Error())
+		}
+		set = append(set, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return set, nil
+}
+
+func (s ipSet) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *IPFilter) validate() error {
+	if _, err := parseIPSet(f.Allow); err != nil {
+		return fmt.Errorf("ip filter allow is invalid: %s", err.Error())
+	}
+	if _, err := parseIPSet(f.Deny); err != nil {
+		return fmt.Errorf("ip filter deny is invalid: %s", err.Error())
+	}
+	return nil
+}
+
+// allows reports whether the client ip is allowed. Addresses which cannot be parsed are denied.
+func (f *IPFilter) allows(ip string) bool {
+	f.once.Do(func() {
+		// f is validated before serving, so the errors are not checked.
+		f.allow, _ = parseIPSet(f.Allow)
+		f.deny, _ = parseIPSet(f.Deny)
+	})
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	if f.deny.contains(addr) {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow.contains(addr)
+}
+
+// serve responds 403 to r if its client is not allowed, and reports whether it is allowed.
+func (f *IPFilter) serve(w http.ResponseWriter, r *http.Request) bool {
+	if ip := ClientIP(r); !f.allows(ip) {
+		respond403(w, r, fmt.Sprintf("client ip(%s) not allowed", ip))
+		return false
+	}
+	return true
+}
+
+// IPFilter adds an IPFilter to the Condition, in addition to the gateway-wide Config.IPFilter and those of its Groups.
+// A request must be allowed by all of them.
+// Example:
+//  g.Conditions().Path("/admin").IPFilter(&gag.IPFilter{Allow: []string{"10.20.0.0/16"}}).Route(...)
+func (c *Condition) IPFilter(filter *IPFilter) *Condition {
+	c.ipFilters = append(c.ipFilters, filter)
+	return c
+}
+
+// IPFilter adds an IPFilter to the Conditions of gr, which checks requests before those of the Conditions.
+func (gr *Group) IPFilter(filter *IPFilter) *Group {
+	gr.ipFilters = append(gr.ipFilters, filter)
+	return gr
+}
+
+// ClientIP returns the IP address of the client which sent r.
+// It is the address of the immediate peer, unless the peer is one of Config.TrustedProxies.
+// Then, the Forwarded header, or the X-Forwarded-For header if there is none, is read from the right,
+// skipping the addresses of trusted proxies, and the first address of an untrusted hop is the client.
+// Forwarding headers sent by untrusted peers are ignored, since they can be forged.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// withClientIP returns a shallow copy of r, whose client ip is resolved trusting trustedProxies.
+func withClientIP(r *http.Request, trustedProxies ipSet) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, resolveClientIP(r, trustedProxies)))
+}
+
+func resolveClientIP(r *http.Request, trustedProxies ipSet) string {
+	ip := peerIP(r)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !trustedProxies.contains(addr) {
+		return ip
+	}
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// The hop is unknown or obfuscated, so the last known address is the closest to the client.
+			return ip
+		}
+		ip = hop.Unmap().String()
+		if !trustedProxies.contains(hop) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// peerIP returns the IP address of the immediate peer of r.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedFor returns the addresses of the hops which r was forwarded by, from the client,
+// read from the Forwarded header, or the X-Forwarded-For header if there is none.
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, forwardedNode(strings.Trim(value, `"`)))
+				}
+			}
+		}
+		return hops
+	}
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, forwardedNode(strings.TrimSpace(hop)))
+		}
+	}
+	return hops
+}
+
+// forwardedNode returns the address of node, such as "192.0.2.1:4711" or "[2001:db8::1]:4711", without its port.
+func forwardedNode(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
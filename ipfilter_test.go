@@ -0,0 +1,116 @@
+package gag
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trustedProxies, err := parseIPSet([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("error parsing trusted proxies: %v", err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		ip         string
+	}{
+		{"untrusted peer", "203.0.113.7:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"trusted peer", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"trusted hops skipped", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1", "192.0.2.1"}}, "198.51.100.1"},
+		{"forwarded preferred", "10.0.0.1:1234", http.Header{"Forwarded": {`for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`}, "X-Forwarded-For": {"198.51.100.1"}}, "2001:db8::1"},
+		{"unknown hop", "10.0.0.1:1234", http.Header{"Forwarded": {"for=198.51.100.1, for=unknown"}}, "10.0.0.1"},
+		{"only trusted hops", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.3"}}, "10.0.0.3"},
+		{"no header", "10.0.0.1:1234", http.Header{}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := &http.Request{RemoteAddr: tt.remoteAddr, Header: tt.header}
+		if ip := resolveClientIP(r, trustedProxies); ip != tt.ip {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.ip, ip)
+		}
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	g := NewGag(Config{
+		IPFilter:       &IPFilter{Deny: []string{"198.51.100.0/24"}},
+		TrustedProxies: []string{"127.0.0.1", "::1"},
+	})
+	g.Group("/admin").IPFilter(&IPFilter{Allow: []string{"10.20.0.0/16"}}).Conditions().
+		Path("/users").IPFilter(&IPFilter{Deny: []string{"10.20.1.0/24"}}).HandlerFunc(sampleHandler(), g)
+	g.Conditions().Path("/public").HandlerFunc(sampleHandler(), g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		path       string
+		clientIP   string
+		statusCode int
+		body       string
+	}{
+		{"/admin/users", "10.20.0.5", http.StatusOK, `{"message":"sample handler!"}`},
+		{"/admin/users", "10.20.1.5", http.StatusForbidden, "403 client ip(10.20.1.5) not allowed"},
+		{"/admin/users", "203.0.113.7", http.StatusForbidden, "403 client ip(203.0.113.7) not allowed"},
+		{"/public", "203.0.113.7", http.StatusOK, `{"message":"sample handler!"}`},
+		{"/public", "198.51.100.1", http.StatusForbidden, "403 client ip(198.51.100.1) not allowed"},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, s.URL+tt.path, nil)
+		r.Header.Set("X-Forwarded-For", tt.clientIP)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		if err := validateResponse(res, tt.statusCode, tt.body); err != nil {
+			t.Errorf("%s from %s: %v", tt.path, tt.clientIP, err)
+		}
+	}
+}
+
+func TestIPFilterBeforeRouteMatching(t *testing.T) {
+	g := NewGag(Config{TrustedProxies: []string{"127.0.0.1", "::1"}})
+	g.Conditions().Path("/internal").Method(http.MethodPost).HasHeader("X-Token").
+		CORS(&CORSConfig{AllowedOrigins: []string{"*"}}).
+		IPFilter(&IPFilter{Allow: []string{"10.20.0.0/16"}}).HandlerFunc(sampleHandler(), g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+	}{
+		{"preflight", http.MethodOptions, http.Header{"Origin": {"https://any.origin"}, "Access-Control-Request-Method": {http.MethodPost}}},
+		{"other method", http.MethodGet, http.Header{}},
+		{"missing header", http.MethodPost, http.Header{}},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(tt.method, s.URL+"/internal", nil)
+		r.Header = tt.header
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		if err := validateResponse(res, http.StatusForbidden, "403 client ip(203.0.113.7) not allowed"); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if res.Header.Get("Access-Control-Allow-Methods") != "" {
+			t.Errorf("%s: expected no CORS headers for a denied client, got %v", tt.name, res.Header)
+		}
+	}
+}
+
+func TestIPFilterValidation(t *testing.T) {
+	g := NewGag(Config{TrustedProxies: []string{"10.0.0.0/33"}})
+	if _, err := g.Handler(); err == nil {
+		t.Error("expected error for invalid trusted proxies")
+	}
+
+	g = NewGag(Config{})
+	g.Conditions().Path("/admin").IPFilter(&IPFilter{Allow: []string{"not an ip"}}).HandlerFunc(sampleHandler(), g)
+	if _, err := g.Handler(); err == nil {
+		t.Error("expected error for invalid ip filter")
+	}
+}
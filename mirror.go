@@ -0,0 +1,178 @@
+package gag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of Mirror.
+const (
+	DefaultMirrorTimeout     = 10 * time.Second
+	DefaultMirrorMaxInFlight = 100
+)
+
+// Mirror contains all properties about how requests routed to RouteRequest.Url are duplicated to a shadow upstream.
+// Mirrored requests are sent asynchronously, and their responses are discarded after being compared with those of the primary.
+// Status code mismatches and failures of the shadow upstream are logged, and all comparisons are counted in MirrorStats.
+// Configure Mirror by setting RouteRequest.Mirror.
+type Mirror struct {
+	// Url is the url of the shadow upstream, which the requests are duplicated to.
+	Url string
+	// HttpMethod is the HTTP method of the duplicated requests. When "", RouteRequest.HttpMethod will be used.
+	HttpMethod string
+	// Percentage is the percentage of requests duplicated, from 0 to 100.
+	Percentage float64
+	// Timeout is the timeout value of the duplicated requests. When 0, RouteRequest.Timeout will be used,
+	// or DefaultMirrorTimeout if it is 0 as well.
+	// Duplicated requests are not canceled when the client goes away, so that they are compared to completion.
+	Timeout time.Duration
+	// MaxInFlight is the maximum number of duplicated requests waiting for the shadow upstream.
+	// Requests are not duplicated while as many are in flight, and counted in MirrorStats.Dropped instead,
+	// so that a slow shadow upstream doesn't pile them up. When 0, DefaultMirrorMaxInFlight will be used.
+	MaxInFlight int
+
+	inFlight atomic.Int64
+	stats    mirrorCounters
+}
+
+// MirrorStats are the metrics of the requests duplicated to the shadow upstream.
+type MirrorStats struct {
+	// Mirrored is the number of requests duplicated.
+	Mirrored int64 `json:"mirrored"`
+	// Dropped is the number of requests not duplicated, since MaxInFlight duplicated requests were in flight.
+	Dropped int64 `json:"dropped"`
+	// Failed is the number of duplicated requests which failed without a response.
+	Failed int64 `json:"failed"`
+	// StatusMismatches is the number of duplicated requests whose status code differed from that of the primary.
+	StatusMismatches int64 `json:"statusMismatches"`
+	// AverageLatencyDiff is the average of the latency of the shadow upstream minus that of the primary.
+	AverageLatencyDiff time.Duration `json:"averageLatencyDiff"`
+}
+
+type mirrorCounters struct {
+	mirrored         int64
+	dropped          int64
+	failed           int64
+	statusMismatches int64
+	compared         int64
+	latencyDiffTotal int64
+}
+
+// mirrorResult is the outcome of a request to the primary or the shadow upstream.
+type mirrorResult struct {
+	statusCode int
+	latency    time.Duration
+	err        error
+}
+
+// Stats returns the metrics of the requests duplicated to m.Url.
+func (m *Mirror) Stats() MirrorStats {
+	stats := MirrorStats{
+		Mirrored:         atomic.LoadInt64(&m.stats.mirrored),
+		Dropped:          atomic.LoadInt64(&m.stats.dropped),
+		Failed:           atomic.LoadInt64(&m.stats.failed),
+		StatusMismatches: atomic.LoadInt64(&m.stats.statusMismatches),
+	}
+	if compared := atomic.LoadInt64(&m.stats.compared); compared > 0 {
+		stats.AverageLatencyDiff = time.Duration(atomic.LoadInt64(&m.stats.latencyDiffTotal) / compared)
+	}
+	return stats
+}
+
+func (m *Mirror) validate() error {
+	if err := validateUpstreamUrl(m.Url, "http", "https"); err != nil {
+		return fmt.Errorf("mirror url(%s) is invalid: %s", m.Url, err.Error())
+	}
+	if m.HttpMethod != "" && !isValidMethod(m.HttpMethod) {
+		return fmt.Errorf("mirror method(%s) is invalid", m.HttpMethod)
+	}
+	if m.Percentage < 0 || m.Percentage > 100 {
+		return fmt.Errorf("mirror percentage(%v) must be between 0 and 100", m.Percentage)
+	}
+	if m.MaxInFlight < 0 {
+		return fmt.Errorf("mirror max in-flight(%d) cannot be negative", m.MaxInFlight)
+	}
+	return nil
+}
+
+// sample reports whether a request should be duplicated, according to m.Percentage.
+func (m *Mirror) sample() bool {
+	return m.Percentage >= 100 || rand.Float64()*100 < m.Percentage
+}
+
+// send duplicates the request to the primary rr.Url, described by header and body, to m.Url asynchronously.
+// The returned channel receives the result of the primary, which the result of the shadow upstream is compared with.
+// The request is dropped when m.MaxInFlight duplicated requests are in flight.
+func (m *Mirror) send(ctx context.Context, r *http.Request, rr *RouteRequest, header http.Header, body []byte) chan<- mirrorResult {
+	primary := make(chan mirrorResult, 1)
+	maxInFlight := m.MaxInFlight
+	if maxInFlight == 0 {
+		maxInFlight = DefaultMirrorMaxInFlight
+	}
+	if m.inFlight.Add(1) > int64(maxInFlight) {
+		m.inFlight.Add(-1)
+		atomic.AddInt64(&m.stats.dropped, 1)
+		return primary
+	}
+	atomic.AddInt64(&m.stats.mirrored, 1)
+
+	method := m.HttpMethod
+	if method == "" {
+		method = rr.HttpMethod
+	}
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = rr.Timeout
+	}
+	if timeout == 0 {
+		timeout = DefaultMirrorTimeout
+	}
+	path := r.URL.Path
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer m.inFlight.Add(-1)
+		shadow := mirrorResult{}
+		start := time.Now()
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, m.Url, reqBody)
+		if err == nil {
+			req.Header = header
+			var resp *http.Response
+			resp, err = (&http.Client{Timeout: timeout}).Do(req)
+			if err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				shadow.statusCode = resp.StatusCode
+			}
+		}
+		shadow.latency = time.Since(start)
+		shadow.err = err
+		m.compare(path, <-primary, shadow)
+	}()
+	return primary
+}
+
+// compare records the difference between the results of the primary and the shadow upstream.
+func (m *Mirror) compare(path string, primary mirrorResult, shadow mirrorResult) {
+	if shadow.err != nil {
+		atomic.AddInt64(&m.stats.failed, 1)
+		logger{}.Println(fmt.Sprintf("mirror %s: shadow(%s) failed: %s", path, m.Url, shadow.err.Error()))
+		return
+	}
+	atomic.AddInt64(&m.stats.compared, 1)
+	atomic.AddInt64(&m.stats.latencyDiffTotal, int64(shadow.latency-primary.latency))
+	if shadow.statusCode != primary.statusCode {
+		atomic.AddInt64(&m.stats.statusMismatches, 1)
+		logger{}.Println(fmt.Sprintf("mirror %s: status code %d from primary, %d from shadow(%s), latency %v from primary, %v from shadow",
+			path, primary.statusCode, shadow.statusCode, m.Url, primary.latency, shadow.latency))
+	}
+}
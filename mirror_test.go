@@ -0,0 +1,109 @@
+package gag
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirrorDuplicatesRequests(t *testing.T) {
+	primary := httptest.NewServer(jsonHandler(`{"from":"primary"}`))
+	defer primary.Close()
+	bodies := make(chan string, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- r.Method + " " + string(body)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	mirror := &Mirror{Url: shadow.URL, Percentage: 100}
+	g := NewGag(Config{})
+	g.Conditions().Path("/orders").Method(http.MethodPost).Route(&RouteRequest{
+		Url:             primary.URL,
+		HttpMethod:      http.MethodPost,
+		PassRequestBody: true,
+		Mirror:          mirror,
+	}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Post(s.URL+"/orders", "application/json", strings.NewReader(`{"id":1}`))
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	if err := validateResponse(res, http.StatusOK, `{"from":"primary"}`); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case body := <-bodies:
+		if body != `POST {"id":1}` {
+			t.Errorf("expected shadow to receive the request body, got %s", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected request to be mirrored")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for mirror.Stats().StatusMismatches == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := mirror.Stats()
+	if stats.Mirrored != 1 || stats.StatusMismatches != 1 || stats.Failed != 0 {
+		t.Errorf("unexpected mirror stats: %+v", stats)
+	}
+}
+
+func TestMirrorPercentage(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{}`))
+	defer upstream.Close()
+
+	mirror := &Mirror{Url: upstream.URL, Percentage: 0}
+	g := NewGag(Config{})
+	g.Conditions().Path("/never").Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet, Mirror: mirror}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	for i := 0; i < 10; i++ {
+		res, err := http.Get(s.URL + "/never")
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		res.Body.Close()
+	}
+	if mirrored := mirror.Stats().Mirrored; mirrored != 0 {
+		t.Errorf("expected no request to be mirrored, got %d", mirrored)
+	}
+}
+
+func TestMirrorDropsWhenMaxInFlight(t *testing.T) {
+	primary := httptest.NewServer(jsonHandler(`{}`))
+	defer primary.Close()
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	mirror := &Mirror{Url: shadow.URL, Percentage: 100, MaxInFlight: 1}
+	g := NewGag(Config{})
+	g.Conditions().Path("/slow").Route(&RouteRequest{Url: primary.URL, HttpMethod: http.MethodGet, Mirror: mirror}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		res, err := http.Get(s.URL + "/slow")
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		res.Body.Close()
+	}
+	if stats := mirror.Stats(); stats.Mirrored != 1 || stats.Dropped != 2 {
+		t.Errorf("expected 1 request mirrored and 2 dropped while the shadow is slow, got %+v", stats)
+	}
+}
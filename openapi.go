@@ -0,0 +1,325 @@
+package gag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// openAPIMethods are the HTTP methods of the operations of an OpenAPI path item, in the order Conditions are generated.
+var openAPIMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPost,
+	http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodTrace,
+}
+
+// OpenAPI is an OpenAPI 3 document, of which the properties used by Gag are parsed.
+// Load it using LoadOpenAPI() or ParseOpenAPI().
+type OpenAPI struct {
+	// OpenAPI is the version of the OpenAPI specification, such as "3.0.3".
+	OpenAPI string `json:"openapi"`
+	// Paths are the path items, keyed by path templates such as "/users/{id}".
+	Paths map[string]*OpenAPIPathItem `json:"paths"`
+}
+
+// OpenAPIPathItem contains the operations of a path.
+type OpenAPIPathItem struct {
+	// Operations are keyed by HTTP methods, such as http.MethodGet.
+	Operations map[string]*OpenAPIOperation
+	// Parameters are shared by the operations, which can override them.
+	Parameters []*OpenAPIParameter
+}
+
+// OpenAPIOperation is an operation of an OpenAPI document.
+type OpenAPIOperation struct {
+	OperationID string   `json:"operationId"`
+	Summary     string   `json:"summary"`
+	Tags        []string `json:"tags"`
+	// Parameters include those of the path item, with references resolved.
+	Parameters  []*OpenAPIParameter `json:"parameters"`
+	RequestBody *OpenAPIRequestBody `json:"requestBody"`
+	// Path and Method are those of the path item which the operation belongs to.
+	Path   string `json:"-"`
+	Method string `json:"-"`
+}
+
+// OpenAPIParameter is a path, query, header or cookie parameter of an OpenAPIOperation.
+type OpenAPIParameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// OpenAPIRequestBody is the request body of an OpenAPIOperation.
+type OpenAPIRequestBody struct {
+	Ref      string `json:"$ref"`
+	Required bool   `json:"required"`
+	// Content are the media types of the body, keyed by content types such as "application/json".
+	Content map[string]*OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIMediaType describes the body of a content type.
+type OpenAPIMediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// UnmarshalJSON parses the operations of the path item, ignoring its other properties.
+func (item *OpenAPIPathItem) UnmarshalJSON(data []byte) error {
+	var properties map[string]json.RawMessage
+	if err := json.Unmarshal(data, &properties); err != nil {
+		return err
+	}
+	if raw, ok := properties["parameters"]; ok {
+		if err := json.Unmarshal(raw, &item.Parameters); err != nil {
+			return fmt.Errorf("parameters are invalid: %s", err.Error())
+		}
+	}
+	item.Operations = map[string]*OpenAPIOperation{}
+	for _, method := range openAPIMethods {
+		raw, ok := properties[strings.ToLower(method)]
+		if !ok {
+			continue
+		}
+		var op OpenAPIOperation
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return fmt.Errorf("operation(%s) is invalid: %s", strings.ToLower(method), err.Error())
+		}
+		op.Method = method
+		item.Operations[method] = &op
+	}
+	return nil
+}
+
+// LoadOpenAPI reads the OpenAPI document at path. See ParseOpenAPI.
+func LoadOpenAPI(path string) (*OpenAPI, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOpenAPI(data)
+}
+
+// ParseOpenAPI parses an OpenAPI 3 document in JSON.
+// Local references of parameters, request bodies and schemas, such as "#/components/schemas/user", are resolved.
+// YAML documents are not supported, since YAML is not provided by the standard library; convert them to JSON first.
+func ParseOpenAPI(data []byte) (*OpenAPI, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return nil, errors.New("OpenAPI document must be JSON")
+	}
+	var doc OpenAPI
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("OpenAPI document is invalid: %s", err.Error())
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("OpenAPI version(%s) is not supported, only 3.x is", doc.OpenAPI)
+	}
+	resolver, err := newRefResolver(data)
+	if err != nil {
+		return nil, err
+	}
+	for path, item := range doc.Paths {
+		for _, op := range item.Operations {
+			op.Path = path
+			if err := op.resolve(resolver, item.Parameters); err != nil {
+				return nil, fmt.Errorf("operation(%s %s) is invalid: %s", op.Method, path, err.Error())
+			}
+		}
+	}
+	return &doc, nil
+}
+
+// resolve resolves the references of op, and prepends the parameters of its path item which op doesn't override.
+func (op *OpenAPIOperation) resolve(resolver *refResolver, pathParameters []*OpenAPIParameter) error {
+	var params []*OpenAPIParameter
+	for _, p := range append(slices.Clone(pathParameters), op.Parameters...) {
+		for p.Ref != "" {
+			ref := p.Ref
+			p = &OpenAPIParameter{}
+			if err := resolver.resolve(ref, p); err != nil {
+				return err
+			}
+		}
+		if err := resolver.resolveSchema(p.Schema); err != nil {
+			return err
+		}
+		i := slices.IndexFunc(params, func(q *OpenAPIParameter) bool { return q.Name == p.Name && q.In == p.In })
+		if i >= 0 {
+			params[i] = p
+		} else {
+			params = append(params, p)
+		}
+	}
+	op.Parameters = params
+
+	for op.RequestBody != nil && op.RequestBody.Ref != "" {
+		ref := op.RequestBody.Ref
+		op.RequestBody = &OpenAPIRequestBody{}
+		if err := resolver.resolve(ref, op.RequestBody); err != nil {
+			return err
+		}
+	}
+	if op.RequestBody != nil {
+		for _, contentType := range sortedKeys(op.RequestBody.Content) {
+			if mediaType := op.RequestBody.Content[contentType]; mediaType != nil {
+				if err := resolver.resolveSchema(mediaType.Schema); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Operations returns the operations of doc, ordered by path and then by HTTP method.
+func (doc *OpenAPI) Operations() []*OpenAPIOperation {
+	paths := sortedKeys(doc.Paths)
+	var ops []*OpenAPIOperation
+	for _, path := range paths {
+		for _, method := range openAPIMethods {
+			if op, ok := doc.Paths[path].Operations[method]; ok {
+				ops = append(ops, op)
+			}
+		}
+	}
+	return ops
+}
+
+// Operation returns the operation of doc whose operationId is id, or nil if there is none.
+func (doc *OpenAPI) Operation(id string) *OpenAPIOperation {
+	for _, op := range doc.Operations() {
+		if op.OperationID == id {
+			return op
+		}
+	}
+	return nil
+}
+
+// OpenAPIRoutes contains all properties about how Conditions are generated from the operations of an OpenAPI document.
+// Each operation generates a Condition of its path and HTTP method, routed to the path of the operation on Upstream
+// using the same HTTP method, with path parameters passed on. Operations having a request body pass it to the upstream.
+// Unlike Conditions added otherwise, the Conditions of the operations of a path are dispatched by HTTP method,
+// so that each of them is reachable.
+type OpenAPIRoutes struct {
+	// Upstream is the url of the service, such as "http://127.0.0.1:8081", which the paths of the operations are appended to.
+	// When "", the Url of Group's RouteDefaults is used.
+	Upstream string
+	// Group is the Group which the Conditions are added to, sharing its path prefix, matchers, middlewares and RouteRequest defaults.
+	// When nil, the Conditions are added to Gag directly.
+	Group *Group
+	// Tags selects the operations having any of the tags. When empty, operations are not selected by tags.
+	Tags []string
+	// OperationIDs selects the operations having any of the ids. When empty, operations are not selected by ids.
+	// When both Tags and OperationIDs are set, operations selected by either are generated.
+	OperationIDs []string
+	// Overrides are the RouteRequests of operations, keyed by operationId, whose unset properties are generated.
+	// A relative Url, such as "/v2/users/{id}", is appended to Upstream.
+	Overrides map[string]*RouteRequest
+	// Configure is called with each generated Condition before it is added, to set properties such as middlewares.
+	Configure func(op *OpenAPIOperation, c *Condition)
+	// Validate determines whether requests are validated against the operations, as with Condition.Validate().
+	Validate bool
+}
+
+// selects reports whether op is selected by the filters of routes.
+func (routes *OpenAPIRoutes) selects(op *OpenAPIOperation) bool {
+	if len(routes.Tags) == 0 && len(routes.OperationIDs) == 0 {
+		return true
+	}
+	if op.OperationID != "" && slices.Contains(routes.OperationIDs, op.OperationID) {
+		return true
+	}
+	for _, tag := range op.Tags {
+		if slices.Contains(routes.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportOpenAPI adds a Condition for each operation of doc selected by routes, as described by OpenAPIRoutes.
+// An error is returned if an OperationID or an override matches no operation.
+// Example:
+//  doc, err := gag.LoadOpenAPI("users.openapi.json")
+//  if err != nil {
+//	  panic(err)
+//  }
+//  err = g.ImportOpenAPI(doc, &gag.OpenAPIRoutes{Upstream: "http://127.0.0.1:8081", Tags: []string{"public"}})
+func (g *Gag) ImportOpenAPI(doc *OpenAPI, routes *OpenAPIRoutes) error {
+	ids := map[string]bool{}
+	for _, op := range doc.Operations() {
+		ids[op.OperationID] = true
+	}
+	for _, id := range routes.OperationIDs {
+		if !ids[id] {
+			return fmt.Errorf("operation(%s) is not found", id)
+		}
+	}
+	for id := range routes.Overrides {
+		if !ids[id] {
+			return fmt.Errorf("operation(%s) of override is not found", id)
+		}
+	}
+
+	for _, op := range doc.Operations() {
+		if !routes.selects(op) {
+			continue
+		}
+		// The override is copied, so that it is left as it is for other imports.
+		rr := &RouteRequest{}
+		if override := routes.Overrides[op.OperationID]; override != nil {
+			rr.inherit(override)
+		}
+		if rr.Url == "" {
+			rr.Url = op.Path
+		}
+		rr.inherit(&RouteRequest{Url: routes.Upstream, HttpMethod: op.Method, PassRequestBody: op.RequestBody != nil})
+
+		c := &Condition{group: routes.Group, pathItem: doc.Paths[op.Path]}
+		c.Path(op.Path).Method(op.Method)
+		if routes.Validate {
+			c.Validate(&RequestValidation{Operation: op})
+		}
+		if routes.Configure != nil {
+			routes.Configure(op, c)
+		}
+		c.Route(rr, g)
+	}
+	return nil
+}
+
+// methodDispatcher dispatches the requests of a path to the Conditions generated from the operations of an OpenAPI path item,
+// by HTTP method. Requests of other methods are handled by the first Condition, which responds 405.
+type methodDispatcher struct {
+	methods  []string
+	handlers []http.Handler
+}
+
+// methodDispatcherKey identifies the Conditions of a methodDispatcher,
+// since a path item can be imported more than once, such as into different Groups.
+type methodDispatcherKey struct {
+	pathItem *OpenAPIPathItem
+	path     string
+}
+
+func (d *methodDispatcher) add(method string, h http.Handler) {
+	d.methods = append(d.methods, method)
+	d.handlers = append(d.handlers, h)
+}
+
+func (d *methodDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.Method
+	if isPreflight(r) {
+		method = r.Header.Get("Access-Control-Request-Method")
+	}
+	if i := slices.Index(d.methods, method); i >= 0 {
+		d.handlers[i].ServeHTTP(w, r)
+		return
+	}
+	d.handlers[0].ServeHTTP(w, r)
+}
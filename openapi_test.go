@@ -0,0 +1,158 @@
+package gag
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testOpenAPI = `{
+	"openapi": "3.0.3",
+	"info": {"title": "users", "version": "1"},
+	"paths": {
+		"/users": {
+			"get": {"operationId": "listUsers", "tags": ["public"]},
+			"post": {"operationId": "createUser", "tags": ["admin"], "requestBody": {"required": true, "content": {"application/json": {}}}}
+		},
+		"/users/{id}": {
+			"parameters": [{"name": "id", "in": "path", "required": true}],
+			"get": {"operationId": "getUser", "tags": ["public"]},
+			"delete": {"operationId": "deleteUser", "tags": ["admin"]}
+		}
+	}
+}`
+
+func TestImportOpenAPI(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.Method + " " + r.URL.Path + " " + string(body)))
+	}))
+	defer upstream.Close()
+
+	doc, err := ParseOpenAPI([]byte(testOpenAPI))
+	if err != nil {
+		t.Fatalf("error parsing OpenAPI: %v", err)
+	}
+	g := NewGag(Config{})
+	err = g.ImportOpenAPI(doc, &OpenAPIRoutes{
+		Group:     g.Group("/api").RouteDefaults(&RouteRequest{Url: upstream.URL}),
+		Tags:      []string{"public"},
+		Overrides: map[string]*RouteRequest{"getUser": {Url: "/v2/users/{id}"}},
+		Configure: func(op *OpenAPIOperation, c *Condition) {
+			c.Middlewares(orderMiddleware(op.OperationID))
+		},
+	})
+	if err != nil {
+		t.Fatalf("error importing OpenAPI: %v", err)
+	}
+	err = g.ImportOpenAPI(doc, &OpenAPIRoutes{Upstream: upstream.URL, OperationIDs: []string{"createUser"}})
+	if err != nil {
+		t.Fatalf("error importing OpenAPI: %v", err)
+	}
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+		order      string
+	}{
+		{http.MethodGet, "/api/users", http.StatusOK, "GET /users ", "listUsers"},
+		{http.MethodGet, "/api/users/7", http.StatusOK, "GET /v2/users/7 ", "getUser"},
+		{http.MethodDelete, "/api/users/7", http.StatusMethodNotAllowed, "405 method(DELETE) not allowed", ""},
+		{http.MethodPost, "/users", http.StatusOK, `POST /users {"name":"sang"}`, ""},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(tt.method, s.URL+tt.path, strings.NewReader(`{"name":"sang"}`))
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		if order := strings.Join(res.Header.Values("X-Order"), ","); order != tt.order {
+			t.Errorf("%s %s: expected middlewares %s, got %s", tt.method, tt.path, tt.order, order)
+		}
+		if err := validateResponse(res, tt.statusCode, tt.body); err != nil {
+			t.Errorf("%s %s: %v", tt.method, tt.path, err)
+		}
+	}
+}
+
+func TestImportOpenAPIErrors(t *testing.T) {
+	if _, err := ParseOpenAPI([]byte("openapi: 3.0.3")); err == nil {
+		t.Error("expected error for YAML document")
+	}
+	if _, err := ParseOpenAPI([]byte(`{"swagger": "2.0"}`)); err == nil {
+		t.Error("expected error for Swagger 2.0 document")
+	}
+
+	doc, err := ParseOpenAPI([]byte(testOpenAPI))
+	if err != nil {
+		t.Fatalf("error parsing OpenAPI: %v", err)
+	}
+	g := NewGag(Config{})
+	if err := g.ImportOpenAPI(doc, &OpenAPIRoutes{OperationIDs: []string{"unknown"}}); err == nil {
+		t.Error("expected error for unknown operationId")
+	}
+	if err := g.ImportOpenAPI(doc, &OpenAPIRoutes{Overrides: map[string]*RouteRequest{"unknown": {}}}); err == nil {
+		t.Error("expected error for override of unknown operationId")
+	}
+}
+
+func TestImportOpenAPIDispatchesMethods(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.Method + " " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	doc, err := ParseOpenAPI([]byte(testOpenAPI))
+	if err != nil {
+		t.Fatalf("error parsing OpenAPI: %v", err)
+	}
+	override := &RouteRequest{Url: "/v2/users/{id}"}
+	overrides := map[string]*RouteRequest{"deleteUser": override}
+	g := NewGag(Config{})
+	if err := g.ImportOpenAPI(doc, &OpenAPIRoutes{Upstream: upstream.URL, Overrides: overrides}); err != nil {
+		t.Fatalf("error importing OpenAPI: %v", err)
+	}
+	if *override != (RouteRequest{Url: "/v2/users/{id}"}) {
+		t.Errorf("expected override to be left as it is, got %+v", override)
+	}
+	other := NewGag(Config{})
+	if err := other.ImportOpenAPI(doc, &OpenAPIRoutes{Upstream: "http://127.0.0.1:8081", Overrides: overrides}); err != nil {
+		t.Fatalf("error importing OpenAPI: %v", err)
+	}
+	if url := other.conditions[3].routeRequest.Url; url != "http://127.0.0.1:8081/v2/users/{id}" {
+		t.Errorf("expected url of the other import to be http://127.0.0.1:8081/v2/users/{id}, got %s", url)
+	}
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}{
+		{http.MethodGet, "/users", http.StatusOK, "GET /users"},
+		{http.MethodPost, "/users", http.StatusOK, "POST /users"},
+		{http.MethodGet, "/users/7", http.StatusOK, "GET /users/7"},
+		{http.MethodDelete, "/users/7", http.StatusOK, "DELETE /v2/users/7"},
+		{http.MethodPut, "/users/7", http.StatusMethodNotAllowed, "405 method(PUT) not allowed"},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(tt.method, s.URL+tt.path, strings.NewReader(`{"name":"sang"}`))
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		if err := validateResponse(res, tt.statusCode, tt.body); err != nil {
+			t.Errorf("%s %s: %v", tt.method, tt.path, err)
+		}
+	}
+}
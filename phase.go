@@ -0,0 +1,169 @@
+package gag
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Phase is a stage of handling a request, at which middlewares or hooks run.
+//
+// A request goes through the phases in the following order:
+//
+//  1. PhasePreRoute: Config.Middlewares, before the path of the request is matched.
+//  2. Route matching: the path, IP filters, body size limit, CORS, HTTP method and header matchers of the Condition and its Groups.
+//  3. PhaseResponse: middlewares seeing every response of the route, including those of the later phases.
+//  4. PhaseAuth: middlewares authenticating and authorizing the request.
+//  5. Middlewares set by Condition.Middlewares() and Group.Middlewares(), where the last middleware runs first.
+//  6. Request validation set by Condition.Validate(), responding 400 to invalid requests.
+//  7. PhasePreUpstream: middlewares running right before the handler, such as those rewriting the request.
+//  8. The handler of the Condition, such as the call to RouteRequest.Url.
+//  9. PhasePostUpstream: UpstreamHooks inspecting or rewriting the upstream response before it is written.
+//     They run for RouteRequest routes only, before RouteRequest.ResponseTransform is applied.
+//
+// Within a phase, the middlewares of enclosing Groups run before those of the Condition,
+// and each list of middlewares runs in the order given.
+type Phase int
+
+// Phases of handling a request.
+const (
+	PhasePreRoute Phase = iota
+	PhaseResponse
+	PhaseAuth
+	PhasePreUpstream
+	PhasePostUpstream
+)
+
+var phaseNames = map[Phase]string{
+	PhasePreRoute:     "pre-route",
+	PhaseResponse:     "response",
+	PhaseAuth:         "auth",
+	PhasePreUpstream:  "pre-upstream",
+	PhasePostUpstream: "post-upstream",
+}
+
+func (p Phase) String() string {
+	if name, ok := phaseNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// UpstreamHook inspects or rewrites the response of RouteRequest.Url before it is written, at PhasePostUpstream.
+// The StatusCode and Body of res are written, while only the Content-Encoding of its Header is relayed,
+// so use a PhaseResponse middleware to set response headers.
+// If a hook returns an error, the response is discarded and 502 is responded.
+type UpstreamHook func(r *http.Request, res *UpstreamResponse) error
+
+// phasedMiddlewares contains the middlewares of each Phase, in the order they run.
+type phasedMiddlewares map[Phase][]Middleware
+
+// add appends middlewares to those of phase.
+func (pm *phasedMiddlewares) add(phase Phase, middlewares []Middleware) {
+	if *pm == nil {
+		*pm = phasedMiddlewares{}
+	}
+	(*pm)[phase] = append((*pm)[phase], middlewares...)
+}
+
+// inherit prepends the middlewares of outer, so that they run first within each Phase.
+func (pm *phasedMiddlewares) inherit(outer phasedMiddlewares) {
+	for phase, middlewares := range outer {
+		pm.add(phase, nil)
+		(*pm)[phase] = append(append(([]Middleware)(nil), middlewares...), (*pm)[phase]...)
+	}
+}
+
+// validate checks whether middlewares are added to phases which can have middlewares of a Condition.
+func (pm phasedMiddlewares) validate() error {
+	for phase, middlewares := range pm {
+		if len(middlewares) == 0 {
+			continue
+		}
+		switch phase {
+		case PhaseResponse, PhaseAuth, PhasePreUpstream:
+		case PhasePreRoute:
+			return fmt.Errorf("%s middlewares can only be set by Config.Middlewares", phase)
+		case PhasePostUpstream:
+			return fmt.Errorf("%s phase has UpstreamHooks instead of middlewares", phase)
+		default:
+			return fmt.Errorf("phase(%s) is unknown", phase)
+		}
+	}
+	return nil
+}
+
+// chain returns h wrapped by middlewares, so that the first middleware runs first.
+func chain(h http.Handler, middlewares []Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// Use adds middlewares to phase of the Condition. They run in the order given.
+// Only PhaseResponse, PhaseAuth and PhasePreUpstream can have middlewares of a Condition.
+// Example:
+//  g.Conditions().Path("/orders").
+//	  Use(gag.PhaseAuth, authMiddleware()).
+//	  Use(gag.PhasePreUpstream, requestIDMiddleware()).
+//	  Route(...)
+func (c *Condition) Use(phase Phase, middlewares ...Middleware) *Condition {
+	c.phases.add(phase, middlewares)
+	return c
+}
+
+// OnUpstreamResponse adds UpstreamHooks, which run in the order given at PhasePostUpstream.
+// Example:
+//  g.Conditions().Path("/users/{id}").OnUpstreamResponse(func(r *http.Request, res *gag.UpstreamResponse) error {
+//	  if res.StatusCode == http.StatusNotFound {
+//		  res.Body = []byte(`{"message":"no such user"}`)
+//	  }
+//	  return nil
+//  }).Route(...)
+func (c *Condition) OnUpstreamResponse(hooks ...UpstreamHook) *Condition {
+	c.upstreamHooks = append(c.upstreamHooks, hooks...)
+	return c
+}
+
+// Use adds middlewares to phase of the Conditions of gr. They run in the order given,
+// before the middlewares of the Conditions in the same phase.
+func (gr *Group) Use(phase Phase, middlewares ...Middleware) *Group {
+	gr.phases.add(phase, middlewares)
+	return gr
+}
+
+// OnUpstreamResponse adds UpstreamHooks to the Conditions of gr, which run before the hooks of the Conditions.
+func (gr *Group) OnUpstreamResponse(hooks ...UpstreamHook) *Group {
+	gr.upstreamHooks = append(gr.upstreamHooks, hooks...)
+	return gr
+}
+
+// upstreamWriter writes the responses of an upstream, after running the hooks and applying the transform.
+type upstreamWriter struct {
+	hooks     []UpstreamHook
+	transform *ResponseTransform
+}
+
+func (uw upstreamWriter) write(w http.ResponseWriter, r *http.Request, res *UpstreamResponse) {
+	for _, hook := range uw.hooks {
+		if err := hook(r, res); err != nil {
+			respond502(w, r, err)
+			return
+		}
+	}
+	if uw.transform != nil {
+		transformed, err := uw.transform.apply(res)
+		if err != nil {
+			respond502(w, r, err)
+			return
+		}
+		res = transformed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// The body is relayed as it is, so its encoding should be relayed as well.
+	if encoding := res.Header.Get("Content-Encoding"); encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.WriteHeader(res.StatusCode)
+	w.Write(res.Body)
+}
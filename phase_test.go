@@ -0,0 +1,95 @@
+package gag
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPhasesRunInDocumentedOrder(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{"name":"sang"}`))
+	defer upstream.Close()
+
+	var order []string
+	record := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	hook := func(name string) UpstreamHook {
+		return func(r *http.Request, res *UpstreamResponse) error {
+			order = append(order, name)
+			res.Body = []byte(strings.Replace(string(res.Body), "sang", name, 1))
+			return nil
+		}
+	}
+
+	g := NewGag(Config{Middlewares: []Middleware{record("pre-route-1"), record("pre-route-2")}})
+	g.Group("/group").
+		Use(PhasePreUpstream, record("group-pre-upstream")).
+		Use(PhaseAuth, record("group-auth")).
+		OnUpstreamResponse(hook("group-hook")).
+		Conditions().
+		Path("/user").
+		Middlewares(record("legacy-2"), record("legacy-1")).
+		Use(PhasePreUpstream, record("pre-upstream")).
+		Use(PhaseAuth, record("auth-1"), record("auth-2")).
+		Use(PhaseResponse, record("response")).
+		OnUpstreamResponse(hook("hook")).
+		Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/group/user")
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	if err := validateResponse(res, http.StatusOK, `{"name":"group-hook"}`); err != nil {
+		t.Error(err)
+	}
+	expected := []string{
+		"pre-route-1", "pre-route-2", "response", "group-auth", "auth-1", "auth-2",
+		"legacy-1", "legacy-2", "group-pre-upstream", "pre-upstream", "group-hook", "hook",
+	}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+}
+
+func TestUpstreamHookError(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{"secret":true}`))
+	defer upstream.Close()
+
+	g := NewGag(Config{})
+	g.Conditions().Path("/checked").OnUpstreamResponse(func(r *http.Request, res *UpstreamResponse) error {
+		return errors.New("response contains secrets")
+	}).Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/checked")
+	if err != nil {
+		t.Fatalf("error doing request: %v", err)
+	}
+	if err := validateResponse(res, http.StatusBadGateway, "502 upstream unavailable"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidatePhases(t *testing.T) {
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/pre-route").Use(PhasePreRoute, orderMiddleware("x")).HandlerFunc(sampleHandler(), g).
+		Path("/hook").OnUpstreamResponse(func(r *http.Request, res *UpstreamResponse) error { return nil }).HandlerFunc(sampleHandler(), g)
+
+	_, err := g.Handler()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("expected 2 validation errors, got %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+package gag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a JSON Schema, as used by OpenAPI documents, of which the following keywords are validated:
+// type, nullable, enum, properties, required, additionalProperties, items, minItems, maxItems,
+// minLength, maxLength, pattern, minimum, maximum, allOf, anyOf, oneOf and local $ref.
+// Other keywords, such as format, are ignored. Parse a Schema using ParseSchema().
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 []string           `json:"-"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *Schema            `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	AllOf                []*Schema          `json:"allOf"`
+	AnyOf                []*Schema          `json:"anyOf"`
+	OneOf                []*Schema          `json:"oneOf"`
+
+	// resolved is the Schema referred by Ref.
+	resolved *Schema
+	// never is true for the false schema, which no value is valid against.
+	never   bool
+	pattern *regexp.Regexp
+}
+
+// schemaError is a violation of a Schema by the value at pointer, a JSON pointer such as "/items/0/name".
+type schemaError struct {
+	pointer string
+	message string
+}
+
+// UnmarshalJSON parses the schema, including the boolean schemas true and false,
+// and type given either as a string or as an array of strings.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{never: true}
+		return nil
+	}
+	type schema Schema
+	raw := struct {
+		*schema
+		Type json.RawMessage `json:"type"`
+	}{schema: (*schema)(s)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw.Type) > 0 {
+		var t string
+		if err := json.Unmarshal(raw.Type, &t); err == nil {
+			s.Type = []string{t}
+		} else if err := json.Unmarshal(raw.Type, &s.Type); err != nil {
+			return fmt.Errorf("schema type(%s) is invalid", string(raw.Type))
+		}
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("schema pattern(%s) is invalid: %s", s.Pattern, err.Error())
+		}
+		s.pattern = pattern
+	}
+	return nil
+}
+
+// ParseSchema parses a JSON Schema in JSON. Local references, such as "#/$defs/user", are resolved.
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("schema is invalid: %s", err.Error())
+	}
+	resolver, err := newRefResolver(data)
+	if err != nil {
+		return nil, err
+	}
+	resolver.schemas["#"] = &s
+	if err := resolver.resolveSchema(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// refResolver resolves local references, such as "#/components/schemas/user", within a document.
+type refResolver struct {
+	root interface{}
+	// schemas are the Schemas resolved, keyed by references, so that a Schema referred more than once,
+	// including by itself, is parsed once.
+	schemas map[string]*Schema
+	// visited are the Schemas whose references are resolved.
+	visited map[*Schema]bool
+}
+
+func newRefResolver(data []byte) (*refResolver, error) {
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	return &refResolver{root: root, schemas: map[string]*Schema{}, visited: map[*Schema]bool{}}, nil
+}
+
+// resolve unmarshals the value referred by ref into v.
+func (rr *refResolver) resolve(ref string, v interface{}) error {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return fmt.Errorf("reference(%s) is not supported, only local references are", ref)
+	}
+	value := rr.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch t := value.(type) {
+		case map[string]interface{}:
+			next, ok := t[token]
+			if !ok {
+				return fmt.Errorf("reference(%s) is not found", ref)
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(t) {
+				return fmt.Errorf("reference(%s) is not found", ref)
+			}
+			value = t[i]
+		default:
+			return fmt.Errorf("reference(%s) is not found", ref)
+		}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("reference(%s) is invalid: %s", ref, err.Error())
+	}
+	return nil
+}
+
+// resolveSchema resolves the references of s and its subschemas.
+func (rr *refResolver) resolveSchema(s *Schema) error {
+	if s == nil || rr.visited[s] {
+		return nil
+	}
+	rr.visited[s] = true
+	if s.Ref != "" {
+		resolved, ok := rr.schemas[s.Ref]
+		if !ok {
+			resolved = &Schema{}
+			if err := rr.resolve(s.Ref, resolved); err != nil {
+				return err
+			}
+			rr.schemas[s.Ref] = resolved
+		}
+		s.resolved = resolved
+		return rr.resolveSchema(resolved)
+	}
+	subschemas := []*Schema{s.AdditionalProperties, s.Items}
+	for _, name := range sortedKeys(s.Properties) {
+		subschemas = append(subschemas, s.Properties[name])
+	}
+	subschemas = append(append(append(subschemas, s.AllOf...), s.AnyOf...), s.OneOf...)
+	for _, subschema := range subschemas {
+		if err := rr.resolveSchema(subschema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate returns the violations of s by v, a value decoded by encoding/json, found at pointer.
+func (s *Schema) validate(v interface{}, pointer string) []schemaError {
+	if s.resolved != nil {
+		return s.resolved.validate(v, pointer)
+	}
+	if s.never {
+		return []schemaError{{pointer, "is not allowed"}}
+	}
+	if v == nil && (s.Nullable || slices.Contains(s.Type, "null")) {
+		return nil
+	}
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return hasJSONType(v, t) }) {
+		return []schemaError{{pointer, fmt.Sprintf("must be of type %s", strings.Join(s.Type, " or "))}}
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e interface{}) bool { return reflect.DeepEqual(e, v) }) {
+		return []schemaError{{pointer, "must be one of the enum values"}}
+	}
+
+	var errs []schemaError
+	switch t := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(t)
+		if s.MinLength != nil && n < *s.MinLength {
+			errs = append(errs, schemaError{pointer, fmt.Sprintf("must be at least %d characters long", *s.MinLength)})
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			errs = append(errs, schemaError{pointer, fmt.Sprintf("must be at most %d characters long", *s.MaxLength)})
+		}
+		if s.pattern != nil && !s.pattern.MatchString(t) {
+			errs = append(errs, schemaError{pointer, fmt.Sprintf("must match pattern %s", s.Pattern)})
+		}
+	case float64:
+		if s.Minimum != nil && t < *s.Minimum {
+			errs = append(errs, schemaError{pointer, fmt.Sprintf("must be at least %v", *s.Minimum)})
+		}
+		if s.Maximum != nil && t > *s.Maximum {
+			errs = append(errs, schemaError{pointer, fmt.Sprintf("must be at most %v", *s.Maximum)})
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := t[name]; !ok {
+				errs = append(errs, schemaError{childPointer(pointer, name), "is required"})
+			}
+		}
+		for _, name := range sortedKeys(t) {
+			child := childPointer(pointer, name)
+			if property, ok := s.Properties[name]; ok {
+				errs = append(errs, property.validate(t[name], child)...)
+			} else if s.AdditionalProperties != nil {
+				errs = append(errs, s.AdditionalProperties.validate(t[name], child)...)
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(t) < *s.MinItems {
+			errs = append(errs, schemaError{pointer, fmt.Sprintf("must have at least %d items", *s.MinItems)})
+		}
+		if s.MaxItems != nil && len(t) > *s.MaxItems {
+			errs = append(errs, schemaError{pointer, fmt.Sprintf("must have at most %d items", *s.MaxItems)})
+		}
+		if s.Items != nil {
+			for i, item := range t {
+				errs = append(errs, s.Items.validate(item, childPointer(pointer, strconv.Itoa(i)))...)
+			}
+		}
+	}
+
+	for _, subschema := range s.AllOf {
+		errs = append(errs, subschema.validate(v, pointer)...)
+	}
+	if len(s.AnyOf) > 0 && !slices.ContainsFunc(s.AnyOf, func(sub *Schema) bool { return len(sub.validate(v, pointer)) == 0 }) {
+		errs = append(errs, schemaError{pointer, "must match at least one of anyOf"})
+	}
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, subschema := range s.OneOf {
+			if len(subschema.validate(v, pointer)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			errs = append(errs, schemaError{pointer, fmt.Sprintf("must match exactly one of oneOf, matched %d", matched)})
+		}
+	}
+	return errs
+}
+
+// hasJSONType reports whether v, a value decoded by encoding/json, is of the JSON Schema type t.
+func hasJSONType(v interface{}, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || (t == "integer" && v == math.Trunc(v))
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	}
+	return false
+}
+
+// childPointer returns the JSON pointer of the child named name of the value at pointer.
+func childPointer(pointer string, name string) string {
+	return pointer + "/" + strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,189 @@
+package gag
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveGag serves g on a random port with the http.Server configured by Gag, and returns its address.
+func serveGag(t *testing.T, g *Gag) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	g.l = l
+	g.configureHandler()
+	g.s = g.httpServer()
+	go g.serve()
+	return l.Addr().String(), func() { g.s.Close() }
+}
+
+func protoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}
+}
+
+func TestH2CListener(t *testing.T) {
+	g := NewGag(Config{H2C: true, MaxConcurrentStreams: 10})
+	g.Conditions().Path("/proto").HandlerFunc(protoHandler(), g)
+	addr, closeServer := serveGag(t, g)
+	defer closeServer()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	h2c := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	res, err := h2c.Get("http://" + addr + "/proto")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	if err := validateResponse(res, http.StatusOK, "HTTP/2.0"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err = http.Get("http://" + addr + "/proto")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	if err := validateResponse(res, http.StatusOK, "HTTP/1.1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHTTP2OverTLSListener(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	defer ts.Close()
+
+	tlsConfig := ts.TLS.Clone()
+	tlsConfig.NextProtos = nil
+	g := NewGag(Config{TLSConfig: tlsConfig, HTTP2: true})
+	g.Conditions().Path("/proto").HandlerFunc(protoHandler(), g)
+	addr, closeServer := serveGag(t, g)
+	defer closeServer()
+
+	client := ts.Client()
+	client.Transport.(*http.Transport).ForceAttemptHTTP2 = true
+	res, err := client.Get("https://" + addr + "/proto")
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	if err := validateResponse(res, http.StatusOK, "HTTP/2.0"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHTTP2RequiresTLS(t *testing.T) {
+	g := NewGag(Config{HTTP2: true})
+	if err := g.Serve(); err == nil {
+		t.Error("expected error when HTTP2 is enabled without TLS")
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	g := NewGag(Config{MaxHeaderBytes: 1024, ReadTimeout: 100 * time.Millisecond})
+	g.Conditions().Path("/proto").HandlerFunc(protoHandler(), g)
+	addr, closeServer := serveGag(t, g)
+	defer closeServer()
+
+	r, err := http.NewRequest(http.MethodGet, "http://"+addr+"/proto", nil)
+	if err != nil {
+		t.Errorf("error creating request: %v", err)
+		return
+	}
+	r.Header.Set("X-Large", strings.Repeat("a", 8192))
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("expected status code %d, got %d", http.StatusRequestHeaderFieldsTooLarge, res.StatusCode)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Errorf("error dialing: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /proto HTTP/1.1\r\n"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Errorf("expected slow request to be closed by the server, got %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	g := NewGag(Config{ReadHeaderTimeout: 100 * time.Millisecond})
+	g.Conditions().Path("/proto").HandlerFunc(protoHandler(), g)
+	addr, closeServer := serveGag(t, g)
+	defer closeServer()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Errorf("error dialing: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /proto HTTP/1.1\r\nHost: gag\r\n"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Errorf("expected slow headers to be closed by the server, got %v", err)
+	}
+}
+
+func TestMaxRequestBodyBytes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+
+	g := NewGag(Config{MaxRequestBodyBytes: 16})
+	g.Conditions().
+		Path("/global").Method(http.MethodPost).Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodPost, PassRequestBody: true}, g).
+		Path("/large").Method(http.MethodPost).MaxBodyBytes(1024).Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodPost, PassRequestBody: true}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		path       string
+		body       io.Reader
+		statusCode int
+	}{
+		{"/global", strings.NewReader(strings.Repeat("a", 16)), http.StatusOK},
+		{"/global", strings.NewReader(strings.Repeat("a", 17)), http.StatusRequestEntityTooLarge},
+		// Bodies of unknown length are limited while they are read.
+		{"/global", ioutil.NopCloser(strings.NewReader(strings.Repeat("a", 17))), http.StatusRequestEntityTooLarge},
+		{"/large", strings.NewReader(strings.Repeat("a", 17)), http.StatusOK},
+		{"/large", strings.NewReader(strings.Repeat("a", 1025)), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		res, err := http.Post(s.URL+tt.path, "application/json", tt.body)
+		if err != nil {
+			t.Errorf("error doing request: %v", err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.statusCode {
+			t.Errorf("%s: expected status code %d, got %d", tt.path, tt.statusCode, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package gag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+)
+
+// TrafficSplit contains all properties about how requests of a route are split between versions of an upstream,
+// such as a stable version and a canary.
+// Configure TrafficSplit by setting RouteRequest.Split, and change the weights while serving
+// using TrafficSplit.SetWeights() or the admin API.
+type TrafficSplit struct {
+	// Versions are the versions of the upstream. Each request is routed to one of them, chosen by their weights.
+	Versions []*UpstreamVersion
+	// StickyCookie is the name of a cookie, such as a session id, whose value determines the version.
+	// Requests having the same value are routed to the same version as long as the weights don't change.
+	StickyCookie string
+	// StickyHeader is the name of a header whose value determines the version, as with StickyCookie.
+	// StickyCookie is used first when both are set.
+	StickyHeader string
+	// OverrideHeader is the name of a header, such as "X-Version", whose value names the version to route to
+	// regardless of the weights. Requests naming an unknown version are split as usual.
+	OverrideHeader string
+
+	// mu guards the Weight of Versions, which can be changed while serving.
+	mu sync.RWMutex
+}
+
+// UpstreamVersion is a version of an upstream, which receives requests in proportion to Weight.
+type UpstreamVersion struct {
+	// Name identifies the version, such as "v2". It must be unique within a TrafficSplit.
+	Name string
+	// Url is the url that the requests will be routed to.
+	Url string
+	// Weight is the relative share of requests routed to the version. A version of weight 0 receives no requests,
+	// except those naming it by TrafficSplit.OverrideHeader.
+	Weight int
+}
+
+// upstreamVersionKey is the context key of the UpstreamVersion chosen for a request.
+type upstreamVersionKey struct{}
+
+// Weights returns the current weight of each version, keyed by UpstreamVersion.Name.
+func (ts *TrafficSplit) Weights() map[string]int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	weights := make(map[string]int, len(ts.Versions))
+	for _, v := range ts.Versions {
+		weights[v.Name] = v.Weight
+	}
+	return weights
+}
+
+// SetWeights changes the weights of the versions named in weights, leaving the others as they are.
+// It returns an error, without changing any weight, if a version is unknown or the weights are invalid.
+func (ts *TrafficSplit) SetWeights(weights map[string]int) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	total := 0
+	for _, v := range ts.Versions {
+		weight, ok := weights[v.Name]
+		if !ok {
+			weight = v.Weight
+		}
+		if weight < 0 {
+			return fmt.Errorf("weight(%d) of version(%s) cannot be negative", weight, v.Name)
+		}
+		total += weight
+	}
+	for name := range weights {
+		if ts.version(name) == nil {
+			return fmt.Errorf("version(%s) is unknown", name)
+		}
+	}
+	if total == 0 {
+		return errors.New("sum of weights must be positive")
+	}
+	for _, v := range ts.Versions {
+		if weight, ok := weights[v.Name]; ok {
+			v.Weight = weight
+		}
+	}
+	return nil
+}
+
+func (ts *TrafficSplit) validate() error {
+	if len(ts.Versions) == 0 {
+		return errors.New("split versions cannot be empty")
+	}
+	names := map[string]bool{}
+	total := 0
+	for _, v := range ts.Versions {
+		if v.Name == "" {
+			return errors.New("split version name cannot be \"\"")
+		}
+		if names[v.Name] {
+			return fmt.Errorf("split version name(%s) is duplicated", v.Name)
+		}
+		names[v.Name] = true
+		if err := validateUpstreamUrl(v.Url, "http", "https", "ws", "wss"); err != nil {
+			return fmt.Errorf("split version(%s) url(%s) is invalid: %s", v.Name, v.Url, err.Error())
+		}
+		if v.Weight < 0 {
+			return fmt.Errorf("split version(%s) weight(%d) cannot be negative", v.Name, v.Weight)
+		}
+		total += v.Weight
+	}
+	if total == 0 {
+		return errors.New("sum of split weights must be positive")
+	}
+	return nil
+}
+
+// version returns the version named name, or nil if there is none.
+func (ts *TrafficSplit) version(name string) *UpstreamVersion {
+	for _, v := range ts.Versions {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// pick chooses the version which r will be routed to.
+func (ts *TrafficSplit) pick(r *http.Request) *UpstreamVersion {
+	if ts.OverrideHeader != "" {
+		if v := ts.version(r.Header.Get(ts.OverrideHeader)); v != nil {
+			return v
+		}
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	total := 0
+	for _, v := range ts.Versions {
+		total += v.Weight
+	}
+	var n int
+	if key := ts.stickyKey(r); key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		n = int(h.Sum32() % uint32(total))
+	} else {
+		n = rand.IntN(total)
+	}
+	for _, v := range ts.Versions {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return ts.Versions[len(ts.Versions)-1]
+}
+
+// stickyKey returns the value of r determining its version, or "" if r is not sticky.
+func (ts *TrafficSplit) stickyKey(r *http.Request) string {
+	if ts.StickyCookie != "" {
+		if c, err := r.Cookie(ts.StickyCookie); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	if ts.StickyHeader != "" {
+		return r.Header.Get(ts.StickyHeader)
+	}
+	return ""
+}
+
+// withUpstreamVersion returns a shallow copy of r, which will be routed to the version chosen by rr.Split.
+// r is returned as it is if rr has no Split.
+func (rr *RouteRequest) withUpstreamVersion(r *http.Request) *http.Request {
+	if rr.Split == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), upstreamVersionKey{}, rr.Split.pick(r)))
+}
@@ -0,0 +1,105 @@
+package gag
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrafficSplit(t *testing.T) {
+	stable := httptest.NewServer(jsonHandler(`{"version":"stable"}`))
+	defer stable.Close()
+	canary := httptest.NewServer(jsonHandler(`{"version":"canary"}`))
+	defer canary.Close()
+
+	split := &TrafficSplit{
+		Versions: []*UpstreamVersion{
+			{Name: "stable", Url: stable.URL, Weight: 100},
+			{Name: "canary", Url: canary.URL, Weight: 0},
+		},
+		StickyCookie:   "session",
+		OverrideHeader: "X-Version",
+	}
+	g := NewGag(Config{Admin: &AdminConfig{Token: "secret"}})
+	g.Conditions().Path("/orders").Route(&RouteRequest{HttpMethod: http.MethodGet, Split: split}, g)
+	s := newTestServer(g)
+	defer s.Close()
+	admin := httptest.NewServer(g.adminHandler())
+	defer admin.Close()
+
+	get := func(header map[string]string, cookie string) string {
+		r, _ := http.NewRequest(http.MethodGet, s.URL+"/orders", nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+
+	for i := 0; i < 10; i++ {
+		if body := get(nil, ""); body != `{"version":"stable"}` {
+			t.Fatalf("expected all requests to be routed to stable, got %s", body)
+		}
+	}
+	if body := get(map[string]string{"X-Version": "canary"}, ""); body != `{"version":"canary"}` {
+		t.Errorf("expected override header to route to canary, got %s", body)
+	}
+
+	res := doAdmin(t, admin.URL, http.MethodPut, "/routes/1/weights", `{"canary":100,"unknown":1}`)
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown version, got %d", res.StatusCode)
+	}
+	res = doAdmin(t, admin.URL, http.MethodPut, "/routes/1/weights", `{"stable":50,"canary":50}`)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 changing weights, got %d", res.StatusCode)
+	}
+	if w := split.Weights(); w["stable"] != 50 || w["canary"] != 50 {
+		t.Errorf("unexpected weights: %v", w)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 20; i++ {
+		session := fmt.Sprintf("session-%d", i)
+		first := get(nil, session)
+		if again := get(nil, session); again != first {
+			t.Errorf("expected %s to stick to %s, got %s", session, first, again)
+		}
+		counts[first]++
+	}
+	if len(counts) != 2 {
+		t.Errorf("expected sessions to be split between both versions, got %v", counts)
+	}
+}
+
+func TestTrafficSplitSetWeights(t *testing.T) {
+	split := &TrafficSplit{Versions: []*UpstreamVersion{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}}}
+	tests := []struct {
+		weights map[string]int
+		isErr   bool
+	}{
+		{map[string]int{"a": 0, "b": 0}, true},
+		{map[string]int{"a": -1}, true},
+		{map[string]int{"c": 1}, true},
+		{map[string]int{"a": 0}, false},
+	}
+	for _, tt := range tests {
+		if err := split.SetWeights(tt.weights); (err != nil) != tt.isErr {
+			t.Errorf("%v: expected error %v, got %v", tt.weights, tt.isErr, err)
+		}
+	}
+	if w := split.Weights(); w["a"] != 0 || w["b"] != 1 {
+		t.Errorf("unexpected weights: %v", w)
+	}
+}
@@ -0,0 +1,616 @@
+package gag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	gorillaMux "github.com/gorilla/mux"
+)
+
+// BodyFormat is the format of a request body handled by RequestTransform.
+type BodyFormat string
+
+// Body formats supported by RequestTransform.
+const (
+	// BodyFormatJSON is a JSON object, sent as "application/json".
+	BodyFormatJSON BodyFormat = "json"
+	// BodyFormatForm is a form, sent as "application/x-www-form-urlencoded".
+	// Nested fields are flattened into dot separated keys, and arrays into repeated keys.
+	BodyFormatForm BodyFormat = "form"
+	// BodyFormatXML is an XML document, sent as "application/xml".
+	// Child elements become fields, and repeated elements become arrays. Attributes are ignored.
+	BodyFormatXML BodyFormat = "xml"
+)
+
+// DefaultXMLRoot is the name of the root element of XML bodies written by RequestTransform.
+const DefaultXMLRoot = "request"
+
+// RequestTransform declares how a request body is rewritten before it is routed.
+// The body is decoded into fields, then Remove, Rename and Set are applied in order,
+// and the result is encoded in the To format.
+// Requests having malformed bodies or unresolvable placeholders are responded with 400.
+type RequestTransform struct {
+	// From is the format of the incoming body.
+	// When "", it is detected from the Content-Type header of the request, defaulting to BodyFormatJSON.
+	From BodyFormat
+	// To is the format of the body sent to RouteRequest.Url. When "", BodyFormatJSON will be used.
+	To BodyFormat
+	// XMLRoot is the name of the root element when To is BodyFormatXML. When "", DefaultXMLRoot will be used.
+	XMLRoot string
+	// Remove contains the dot separated paths of the fields to remove, such as "user.password".
+	Remove []string
+	// Rename maps the dot separated paths of fields to their new paths, such as "userName" to "user.name".
+	Rename map[string]string
+	// Set adds or replaces fields. Keys are dot separated paths, and values are templates
+	// which may contain placeholders:
+	//  {id}              is replaced with the path variable "id".
+	//  {header.X-User}   is replaced with the request header "X-User".
+	//  {query.page}      is replaced with the query parameter "page".
+	//  {claims.user.id}  is replaced with the claim "user.id" set by WithClaims.
+	// A value consisting of a single claims placeholder keeps the type of the claim, such as an array.
+	Set map[string]string
+}
+
+// claimsKey is the context key of the claims set by WithClaims.
+type claimsKey struct{}
+
+// WithClaims returns a shallow copy of r carrying claims, such as the claims of a verified token.
+// Authentication middlewares use WithClaims so that RequestTransform can inject claims into request bodies.
+func WithClaims(r *http.Request, claims map[string]interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims))
+}
+
+// Claims returns the claims set by WithClaims, or nil if none are set.
+func Claims(r *http.Request) map[string]interface{} {
+	claims, _ := r.Context().Value(claimsKey{}).(map[string]interface{})
+	return claims
+}
+
+// errBadRequestBody is returned when a request body cannot be transformed because of the request.
+type errBadRequestBody struct {
+	err error
+}
+
+func (e *errBadRequestBody) Error() string {
+	return e.err.Error()
+}
+
+func (e *errBadRequestBody) Unwrap() error {
+	return e.err
+}
+
+func (t *RequestTransform) validate() error {
+	for _, f := range []BodyFormat{t.From, t.To} {
+		if f != "" && f != BodyFormatJSON && f != BodyFormatForm && f != BodyFormatXML {
+			return fmt.Errorf("body format(%s) is not supported", f)
+		}
+	}
+	return nil
+}
+
+// apply reads the body of r and returns the transformed body along with its Content-Type.
+func (t *RequestTransform) apply(r *http.Request) ([]byte, string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	from := t.From
+	if from == "" {
+		if from, err = detectBodyFormat(r.Header.Get("Content-Type")); err != nil {
+			return nil, "", &errBadRequestBody{err}
+		}
+	}
+	fields, err := decodeBody(from, body)
+	if err != nil {
+		return nil, "", &errBadRequestBody{fmt.Errorf("malformed %s body: %s", from, err.Error())}
+	}
+
+	for _, path := range t.Remove {
+		deleteField(fields, strings.Split(path, "."))
+	}
+	for _, from := range sortedKeys(t.Rename) {
+		if v, ok := deleteField(fields, strings.Split(from, ".")); ok {
+			setField(fields, strings.Split(t.Rename[from], "."), v)
+		}
+	}
+	for _, path := range sortedKeys(t.Set) {
+		v, err := expandTemplate(t.Set[path], r)
+		if err != nil {
+			return nil, "", &errBadRequestBody{err}
+		}
+		setField(fields, strings.Split(path, "."), v)
+	}
+
+	switch t.To {
+	case BodyFormatForm:
+		return encodeForm(fields), "application/x-www-form-urlencoded", nil
+	case BodyFormatXML:
+		root := t.XMLRoot
+		if root == "" {
+			root = DefaultXMLRoot
+		}
+		encoded, err := encodeXML(root, fields)
+		return encoded, "application/xml", err
+	default:
+		encoded, err := json.Marshal(fields)
+		return encoded, "application/json", err
+	}
+}
+
+// detectBodyFormat returns the BodyFormat of contentType.
+func detectBodyFormat(contentType string) (BodyFormat, error) {
+	if contentType == "" {
+		return BodyFormatJSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("content type(%s) is malformed", contentType)
+	}
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return BodyFormatForm, nil
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return BodyFormatJSON, nil
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return BodyFormatXML, nil
+	}
+	return "", fmt.Errorf("content type(%s) cannot be transformed", contentType)
+}
+
+// decodeBody decodes body of format into fields. An empty body has no fields.
+func decodeBody(format BodyFormat, body []byte) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return fields, nil
+	}
+	switch format {
+	case BodyFormatForm:
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			if len(v) == 1 {
+				setField(fields, strings.Split(k, "."), v[0])
+				continue
+			}
+			list := make([]interface{}, 0, len(v))
+			for _, s := range v {
+				list = append(list, s)
+			}
+			setField(fields, strings.Split(k, "."), list)
+		}
+		return fields, nil
+	case BodyFormatXML:
+		return decodeXML(body)
+	default:
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		if err := d.Decode(&fields); err != nil {
+			return nil, err
+		}
+		return fields, nil
+	}
+}
+
+// decodeXML decodes the children of the root element of body into fields.
+func decodeXML(body []byte) (map[string]interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := tok.(xml.StartElement); !ok {
+			continue
+		}
+		v, err := decodeXMLElement(d)
+		if err != nil {
+			return nil, err
+		}
+		if fields, ok := v.(map[string]interface{}); ok {
+			return fields, nil
+		}
+		if v != "" {
+			return nil, errors.New("root element must contain elements")
+		}
+		return map[string]interface{}{}, nil
+	}
+}
+
+// decodeXMLElement decodes the element whose start has just been read from d.
+// Elements having children are decoded into maps, and the others into their text.
+func decodeXMLElement(d *xml.Decoder) (interface{}, error) {
+	var children map[string]interface{}
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			v, err := decodeXMLElement(d)
+			if err != nil {
+				return nil, err
+			}
+			if children == nil {
+				children = map[string]interface{}{}
+			}
+			name := t.Name.Local
+			switch existing := children[name].(type) {
+			case nil:
+				children[name] = v
+			case []interface{}:
+				children[name] = append(existing, v)
+			default:
+				children[name] = []interface{}{existing, v}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if children != nil {
+				return children, nil
+			}
+			return strings.TrimSpace(text.String()), nil
+		}
+	}
+}
+
+// encodeXML encodes fields as the children of an element named root.
+func encodeXML(root string, fields map[string]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	e := xml.NewEncoder(&b)
+	if err := encodeXMLValue(e, root, fields); err != nil {
+		return nil, err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func encodeXMLValue(e *xml.Encoder, name string, v interface{}) error {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if err := encodeXMLValue(e, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			if err := encodeXMLValue(e, k, t[k]); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := e.EncodeToken(xml.CharData(fmt.Sprint(t))); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// encodeForm encodes fields as a form, flattening nested fields into dot separated keys.
+func encodeForm(fields map[string]interface{}) []byte {
+	values := url.Values{}
+	flattenForm(values, "", fields)
+	return []byte(values.Encode())
+}
+
+func flattenForm(values url.Values, key string, v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if key != "" {
+				k = key + "." + k
+			}
+			flattenForm(values, k, child)
+		}
+	case []interface{}:
+		for _, child := range t {
+			flattenForm(values, key, child)
+		}
+	case nil:
+		values.Add(key, "")
+	default:
+		values.Add(key, fmt.Sprint(t))
+	}
+}
+
+// expandTemplate replaces the placeholders of template with data of r.
+func expandTemplate(template string, r *http.Request) (interface{}, error) {
+	if strings.HasPrefix(template, "{claims.") && strings.Index(template, "}") == len(template)-1 {
+		key := template[1 : len(template)-1]
+		v, ok := lookupField(Claims(r), strings.Split(strings.TrimPrefix(key, "claims."), "."))
+		if !ok {
+			return nil, fmt.Errorf("placeholder(%s) cannot be resolved", key)
+		}
+		return v, nil
+	}
+
+	vars := gorillaMux.Vars(r)
+	var b strings.Builder
+	for {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			b.WriteString(template)
+			return b.String(), nil
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in template(%s)", template)
+		}
+		end += start
+
+		b.WriteString(template[:start])
+		key := template[start+1 : end]
+		v, ok := resolvePlaceholder(key, r, vars)
+		if !ok {
+			return nil, fmt.Errorf("placeholder(%s) cannot be resolved", key)
+		}
+		b.WriteString(v)
+		template = template[end+1:]
+	}
+}
+
+// resolvePlaceholder returns the request data referenced by key.
+func resolvePlaceholder(key string, r *http.Request, vars map[string]string) (string, bool) {
+	switch {
+	case strings.HasPrefix(key, "header."):
+		name := strings.TrimPrefix(key, "header.")
+		if _, ok := r.Header[http.CanonicalHeaderKey(name)]; !ok {
+			return "", false
+		}
+		return r.Header.Get(name), true
+	case strings.HasPrefix(key, "query."):
+		values, ok := r.URL.Query()[strings.TrimPrefix(key, "query.")]
+		if !ok {
+			return "", false
+		}
+		return values[0], true
+	case strings.HasPrefix(key, "claims."):
+		v, ok := lookupField(Claims(r), strings.Split(strings.TrimPrefix(key, "claims."), "."))
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprint(v), true
+	}
+	v, ok := vars[key]
+	return v, ok
+}
+
+// setField sets v at path of fields, creating intermediate objects as needed.
+func setField(fields map[string]interface{}, path []string, v interface{}) {
+	m := fields
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = v
+}
+
+// deleteField removes the field at path of fields, and returns its value.
+func deleteField(fields map[string]interface{}, path []string) (interface{}, bool) {
+	parent, ok := lookupField(fields, path[:len(path)-1])
+	if !ok {
+		return nil, false
+	}
+	m, ok := parent.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := m[path[len(path)-1]]
+	if ok {
+		delete(m, path[len(path)-1])
+	}
+	return v, ok
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ResponseTransform declares how the response of RouteRequest.Url is rewritten before it is written.
+// JSON object bodies, or arrays of objects, are filtered and renamed field by field,
+// then Allow, Deny, Rename and Envelope are applied in order.
+// Bodies which are not JSON, or are encoded by Content-Encoding, are written as they are,
+// unless Allow is set, in which case they are responded with 502, since the fields to keep cannot be picked from them.
+type ResponseTransform struct {
+	// Allow contains the dot separated paths of the fields to keep, such as "user.name".
+	// If not empty, all the other fields are removed.
+	// A path through an array applies to each object of the array, such as "items.name".
+	Allow []string
+	// Deny contains the dot separated paths of the fields to remove.
+	// As with Allow, a path through an array applies to each object of the array.
+	Deny []string
+	// Rename maps the dot separated paths of fields to their new paths.
+	// Unlike Allow and Deny, the paths do not go through arrays.
+	Rename map[string]string
+	// Envelope wraps the body in an object, under the field named Envelope, such as "data".
+	Envelope string
+	// StatusCodes maps the status codes of the Url to the status codes written, such as 404 to 204.
+	// The body is removed when mapped to 204 or 304, which do not allow a body.
+	StatusCodes map[int]int
+}
+
+func (t *ResponseTransform) validate() error {
+	for from, to := range t.StatusCodes {
+		// 1xx status codes are informational, and cannot be the final status code of a response.
+		if to < 200 || to > 599 {
+			return fmt.Errorf("status code(%d) mapped from %d is invalid", to, from)
+		}
+	}
+	return nil
+}
+
+// apply returns res transformed by t, or an error if t.Allow is set and the body is not a JSON object or array.
+func (t *ResponseTransform) apply(res *UpstreamResponse) (*UpstreamResponse, error) {
+	transformed := *res
+	if statusCode, ok := t.StatusCodes[res.StatusCode]; ok {
+		transformed.StatusCode = statusCode
+	}
+	if transformed.StatusCode == http.StatusNoContent || transformed.StatusCode == http.StatusNotModified {
+		transformed.Body = nil
+		return &transformed, nil
+	}
+	if len(bytes.TrimSpace(res.Body)) == 0 {
+		return &transformed, nil
+	}
+	if encoding := res.Header.Get("Content-Encoding"); encoding != "" {
+		if len(t.Allow) > 0 {
+			return nil, fmt.Errorf("response body encoded by %s cannot be filtered", encoding)
+		}
+		return &transformed, nil
+	}
+	if len(t.Allow) == 0 && len(t.Deny) == 0 && len(t.Rename) == 0 && t.Envelope == "" {
+		return &transformed, nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader(res.Body))
+	d.UseNumber()
+	var body interface{}
+	if err := d.Decode(&body); err != nil {
+		if len(t.Allow) > 0 {
+			return nil, fmt.Errorf("response body cannot be filtered: %s", err.Error())
+		}
+		return &transformed, nil
+	}
+	if len(t.Allow) > 0 && d.More() {
+		return nil, errors.New("response body cannot be filtered, since it has data after the JSON value")
+	}
+	switch v := body.(type) {
+	case map[string]interface{}:
+		body = t.transformFields(v)
+	case []interface{}:
+		for i, item := range v {
+			if fields, ok := item.(map[string]interface{}); ok {
+				v[i] = t.transformFields(fields)
+			}
+		}
+	default:
+		if len(t.Allow) > 0 {
+			return nil, errors.New("response body cannot be filtered, since it is not a JSON object or array")
+		}
+	}
+	if t.Envelope != "" {
+		body = map[string]interface{}{t.Envelope: body}
+	}
+	if encoded, err := json.Marshal(body); err == nil {
+		transformed.Body = encoded
+	}
+	return &transformed, nil
+}
+
+func (t *ResponseTransform) transformFields(fields map[string]interface{}) map[string]interface{} {
+	if len(t.Allow) > 0 {
+		allowed := map[string]interface{}{}
+		for _, path := range t.Allow {
+			allowField(allowed, fields, strings.Split(path, "."))
+		}
+		fields = allowed
+	}
+	for _, path := range t.Deny {
+		denyField(fields, strings.Split(path, "."))
+	}
+	for _, from := range sortedKeys(t.Rename) {
+		if v, ok := deleteField(fields, strings.Split(from, ".")); ok {
+			setField(fields, strings.Split(t.Rename[from], "."), v)
+		}
+	}
+	return fields
+}
+
+// allowField copies the field at path of fields to allowed, applying the rest of path
+// to each object of the arrays on the way.
+func allowField(allowed map[string]interface{}, fields map[string]interface{}, path []string) {
+	v, ok := fields[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		allowed[path[0]] = v
+		return
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		next, ok := allowed[path[0]].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+		}
+		allowField(next, t, path[1:])
+		if len(next) > 0 {
+			allowed[path[0]] = next
+		}
+	case []interface{}:
+		items, ok := allowed[path[0]].([]interface{})
+		if !ok || len(items) != len(t) {
+			// Items which are not objects have no fields to filter, so they are kept as they are.
+			items = make([]interface{}, len(t))
+			copy(items, t)
+			for i, item := range t {
+				if _, ok := item.(map[string]interface{}); ok {
+					items[i] = map[string]interface{}{}
+				}
+			}
+		}
+		for i, item := range t {
+			if object, ok := item.(map[string]interface{}); ok {
+				if next, ok := items[i].(map[string]interface{}); ok {
+					allowField(next, object, path[1:])
+				}
+			}
+		}
+		allowed[path[0]] = items
+	}
+}
+
+// denyField removes the field at path of fields, applying the rest of path
+// to each object of the arrays on the way.
+func denyField(fields map[string]interface{}, path []string) {
+	v, ok := fields[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		delete(fields, path[0])
+		return
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		denyField(t, path[1:])
+	case []interface{}:
+		for _, item := range t {
+			if object, ok := item.(map[string]interface{}); ok {
+				denyField(object, path[1:])
+			}
+		}
+	}
+}
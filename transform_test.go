@@ -0,0 +1,229 @@
+package gag
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoHandler responds with the Content-Type and body of the request, separated by a new line.
+func echoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.Header.Get("Content-Type") + "\n" + string(body)))
+	}
+}
+
+func claimsMiddleware(claims map[string]interface{}) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, WithClaims(r, claims))
+		})
+	}
+}
+
+func TestRequestTransform(t *testing.T) {
+	upstream := httptest.NewServer(echoHandler())
+	defer upstream.Close()
+
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/users/{id}").Method(http.MethodPost).
+		Middlewares(claimsMiddleware(map[string]interface{}{"sub": "sang", "roles": []interface{}{"admin"}})).
+		Route(&RouteRequest{
+			Url:             upstream.URL,
+			HttpMethod:      http.MethodPost,
+			PassRequestBody: true,
+			RequestTransform: &RequestTransform{
+				Remove: []string{"password"},
+				Rename: map[string]string{"userName": "user.name"},
+				Set: map[string]string{
+					"user.id":   "{id}",
+					"roles":     "{claims.roles}",
+					"requester": "{claims.sub} via {header.X-Client}",
+				},
+			},
+		}, g).
+		Path("/form").Method(http.MethodPost).Route(&RouteRequest{
+		Url:              upstream.URL,
+		HttpMethod:       http.MethodPost,
+		PassRequestBody:  true,
+		RequestTransform: &RequestTransform{To: BodyFormatXML, XMLRoot: "order"},
+	}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		header      string
+		body        string
+		statusCode  int
+		expected    string
+	}{
+		{
+			name:       "json rewrite",
+			path:       "/users/7",
+			header:     "mobile",
+			body:       `{"userName":"sang","password":"secret","age":30}`,
+			statusCode: http.StatusOK,
+			expected:   "application/json\n" + `{"age":30,"requester":"sang via mobile","roles":["admin"],"user":{"id":"7","name":"sang"}}`,
+		},
+		{
+			name:       "unresolvable placeholder",
+			path:       "/users/7",
+			body:       `{}`,
+			statusCode: http.StatusBadRequest,
+			expected:   "400 placeholder(header.X-Client) cannot be resolved",
+		},
+		{
+			name:       "malformed body",
+			path:       "/users/7",
+			header:     "mobile",
+			body:       `{"userName":`,
+			statusCode: http.StatusBadRequest,
+			expected:   "400 malformed json body: unexpected EOF",
+		},
+		{
+			name:        "form to xml",
+			path:        "/form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "item=book&item=pen&buyer.name=sang",
+			statusCode:  http.StatusOK,
+			expected:    "application/xml\n<order><buyer><name>sang</name></buyer><item>book</item><item>pen</item></order>",
+		},
+		{
+			name:        "unsupported content type",
+			path:        "/form",
+			contentType: "text/plain",
+			body:        "hello",
+			statusCode:  http.StatusBadRequest,
+			expected:    "400 content type(text/plain) cannot be transformed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodPost, s.URL+tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.header != "" {
+				r.Header.Set("X-Client", tt.header)
+			}
+			res, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatalf("error doing request: %v", err)
+			}
+			if err := validateResponse(res, tt.statusCode, tt.expected); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDecodeXMLBody(t *testing.T) {
+	fields, err := decodeBody(BodyFormatXML, []byte(`<?xml version="1.0"?><req><name>sang</name><tag>a</tag><tag>b</tag><empty/></req>`))
+	if err != nil {
+		t.Fatalf("error decoding body: %v", err)
+	}
+	encoded := string(encodeForm(fields))
+	if encoded != "empty=&name=sang&tag=a&tag=b" {
+		t.Errorf("unexpected fields: %s", encoded)
+	}
+}
+
+func TestResponseTransform(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", jsonHandler(`{"id":1,"name":"sang","profile":{"email":"a@b.c","bio":"long"},"internal":true}`))
+	mux.HandleFunc("/users", jsonHandler(`[{"id":1,"name":"sang"},{"id":2,"name":"kim"}]`))
+	mux.HandleFunc("/order", jsonHandler(`{"id":1,"items":[{"name":"pen","price":2,"stock":9},{"name":"ink","price":5,"stock":0},"gift"]}`))
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("secret"))
+	})
+	mux.HandleFunc("/trailing", jsonHandler(`{"id":1} {"secret":true}`))
+	mux.HandleFunc("/scalar", jsonHandler(`"secret"`))
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"not found"}`))
+	})
+	upstream := httptest.NewServer(mux)
+	defer upstream.Close()
+
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/allow").Route(&RouteRequest{Url: upstream.URL + "/user", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		Allow:    []string{"id", "profile.email"},
+		Rename:   map[string]string{"profile.email": "email"},
+		Envelope: "data",
+	}}, g).
+		Path("/deny").Route(&RouteRequest{Url: upstream.URL + "/user", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		Deny: []string{"internal", "profile.bio"},
+	}}, g).
+		Path("/list").Route(&RouteRequest{Url: upstream.URL + "/users", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		Deny: []string{"id"},
+	}}, g).
+		Path("/missing").Route(&RouteRequest{Url: upstream.URL + "/missing", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		StatusCodes: map[int]int{http.StatusNotFound: http.StatusNoContent},
+	}}, g).
+		Path("/gone").Route(&RouteRequest{Url: upstream.URL + "/gone", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		StatusCodes: map[int]int{http.StatusNotFound: http.StatusNoContent},
+	}}, g).
+		Path("/allow-items").Route(&RouteRequest{Url: upstream.URL + "/order", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		Allow: []string{"items.name", "items.price"},
+	}}, g).
+		Path("/allow-text").Route(&RouteRequest{Url: upstream.URL + "/text", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		Allow: []string{"id"},
+	}}, g).
+		Path("/allow-trailing").Route(&RouteRequest{Url: upstream.URL + "/trailing", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		Allow: []string{"id"},
+	}}, g).
+		Path("/allow-scalar").Route(&RouteRequest{Url: upstream.URL + "/scalar", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		Allow: []string{"id"},
+	}}, g).
+		Path("/deny-text").Route(&RouteRequest{Url: upstream.URL + "/text", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		Deny: []string{"id"},
+	}}, g).
+		Path("/deny-items").Route(&RouteRequest{Url: upstream.URL + "/order", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		Deny: []string{"items.stock"},
+	}}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		path       string
+		statusCode int
+		expected   string
+	}{
+		{"/allow", http.StatusOK, `{"data":{"email":"a@b.c","id":1,"profile":{}}}`},
+		{"/deny", http.StatusOK, `{"id":1,"name":"sang","profile":{"email":"a@b.c"}}`},
+		{"/list", http.StatusOK, `[{"name":"sang"},{"name":"kim"}]`},
+		{"/missing", http.StatusNoContent, ``},
+		{"/gone", http.StatusNoContent, ``},
+		{"/allow-items", http.StatusOK, `{"items":[{"name":"pen","price":2},{"name":"ink","price":5},"gift"]}`},
+		{"/allow-text", http.StatusBadGateway, "502 upstream unavailable"},
+		{"/allow-trailing", http.StatusBadGateway, "502 upstream unavailable"},
+		{"/allow-scalar", http.StatusBadGateway, "502 upstream unavailable"},
+		{"/deny-text", http.StatusOK, "secret"},
+		{"/deny-items", http.StatusOK, `{"id":1,"items":[{"name":"pen","price":2},{"name":"ink","price":5},"gift"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			res, err := http.Get(s.URL + tt.path)
+			if err != nil {
+				t.Fatalf("error doing request: %v", err)
+			}
+			if err := validateResponse(res, tt.statusCode, tt.expected); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package gag
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TunnelStats contains connection-count metrics of the upgraded connections tunneled by a RouteRequest.
+type TunnelStats struct {
+	// Active is the number of connections being tunneled.
+	Active int64
+	// Total is the number of connections tunneled since the start.
+	Total int64
+	// Failed is the number of upgrade requests which failed to be tunneled.
+	Failed int64
+}
+
+// tunnelCounters are the counters behind TunnelStats.
+type tunnelCounters struct {
+	active int64
+	total  int64
+	failed int64
+}
+
+// Tunnels returns connection-count metrics of the upgraded connections tunneled to rr.Url.
+func (rr *RouteRequest) Tunnels() TunnelStats {
+	return TunnelStats{
+		Active: atomic.LoadInt64(&rr.tunnels.active),
+		Total:  atomic.LoadInt64(&rr.tunnels.total),
+		Failed: atomic.LoadInt64(&rr.tunnels.failed),
+	}
+}
+
+// isUpgradeRequest reports whether r asks to upgrade the connection, such as a WebSocket handshake.
+func isUpgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && headerHasToken(r.Header, "Connection", "upgrade")
+}
+
+func headerHasToken(header http.Header, key string, token string) bool {
+	for _, v := range header.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tunnel relays the upgrade request r to rr.Url, and when the upstream switches protocols,
+// copies data between the client and the upstream connections until either side closes
+// or no data is transferred for rr.UpgradeIdleTimeout.
+func (rr *RouteRequest) tunnel(w http.ResponseWriter, r *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		respond500(w, r, errors.New("connection upgrade is not supported"))
+		return
+	}
+
+	rawTarget, err := rr.target(r)
+	if err != nil {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		respond500(w, r, err)
+		return
+	}
+	if err := rr.health.allow(rr.CircuitBreaker); err != nil {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		respondUpstreamError(w, r, err)
+		return
+	}
+	target, err := url.Parse(rr.endpoint(rawTarget))
+	if err != nil {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		respond500(w, r, err)
+		return
+	}
+	upstream, err := dialUpstream(target, rr.Timeout)
+	if err != nil {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		respondUpstreamError(w, r, err)
+		return
+	}
+	defer upstream.Close()
+
+	method := rr.HttpMethod
+	if method == "" {
+		method = r.Method
+	}
+	req, err := http.NewRequest(method, target.String(), nil)
+	if err != nil {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		respond500(w, r, err)
+		return
+	}
+	req.Header = r.Header.Clone()
+	if rr.Timeout > 0 {
+		upstream.SetDeadline(time.Now().Add(rr.Timeout))
+	}
+	if err := req.Write(upstream); err != nil {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		respondUpstreamError(w, r, err)
+		return
+	}
+	upstreamReader := bufio.NewReader(upstream)
+	resp, err := http.ReadResponse(upstreamReader, req)
+	if err != nil {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		respondUpstreamError(w, r, err)
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The upstream refused to upgrade, so its response is relayed as a plain response.
+		defer resp.Body.Close()
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	upstream.SetDeadline(time.Time{})
+
+	client, clientBuf, err := hj.Hijack()
+	if err != nil {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		respond500(w, r, err)
+		return
+	}
+	defer client.Close()
+	// The tunnel outlives the deadlines set by the ReadTimeout and WriteTimeout of the server,
+	// and is bound by rr.UpgradeIdleTimeout instead. net/http clears them on Hijack,
+	// but other servers serving Gag's Handler may not.
+	client.SetDeadline(time.Time{})
+	if err := resp.Write(client); err != nil {
+		atomic.AddInt64(&rr.tunnels.failed, 1)
+		return
+	}
+
+	atomic.AddInt64(&rr.tunnels.total, 1)
+	atomic.AddInt64(&rr.tunnels.active, 1)
+	defer atomic.AddInt64(&rr.tunnels.active, -1)
+
+	idle := &idleTracker{timeout: rr.UpgradeIdleTimeout}
+	idle.touch()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyIdle(upstream, client, clientBuf.Reader, idle)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		copyIdle(client, upstream, upstreamReader, idle)
+		closeWrite(client)
+	}()
+	wg.Wait()
+}
+
+// dialUpstream opens a connection to target, using TLS for https and wss schemes.
+func dialUpstream(target *url.URL, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	host := target.Host
+	switch target.Scheme {
+	case "https", "wss":
+		if target.Port() == "" {
+			host = net.JoinHostPort(target.Hostname(), "443")
+		}
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: target.Hostname()})
+	case "http", "ws":
+		if target.Port() == "" {
+			host = net.JoinHostPort(target.Hostname(), "80")
+		}
+		return dialer.Dial("tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported scheme(%s) for connection upgrade", target.Scheme)
+	}
+}
+
+// idleTracker records the last time data was transferred in either direction of a tunnel.
+type idleTracker struct {
+	timeout      time.Duration
+	lastActivity int64
+}
+
+func (t *idleTracker) touch() {
+	atomic.StoreInt64(&t.lastActivity, time.Now().UnixNano())
+}
+
+func (t *idleTracker) idle() bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&t.lastActivity))) >= t.timeout
+}
+
+// copyIdle copies from src, read through buffered, to dst until src is closed
+// or the tunnel is idle. When idle.timeout is 0, copyIdle never times out.
+func copyIdle(dst net.Conn, src net.Conn, buffered io.Reader, idle *idleTracker) {
+	buf := make([]byte, 32*1024)
+	for {
+		if idle.timeout > 0 {
+			src.SetReadDeadline(time.Now().Add(idle.timeout))
+		}
+		n, err := buffered.Read(buf)
+		if n > 0 {
+			idle.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if !idle.idle() {
+					// Data is still transferred in the other direction.
+					continue
+				}
+				// Unblock the other direction as well, since the tunnel is idle.
+				dst.SetReadDeadline(time.Now())
+			}
+			return
+		}
+	}
+}
+
+// closeWrite signals the end of data to conn, closing it if half-close is not supported.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,165 @@
+package gag
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEchoUpgradeUpstream returns an upstream which switches to an echo protocol on upgrade requests.
+func newEchoUpgradeUpstream() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			brw.WriteString(line)
+			brw.Flush()
+		}
+	}))
+}
+
+func dialUpgrade(t *testing.T, url string, path string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatalf("error dialing gag: %v", err)
+	}
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: gag\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n", path)
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("error reading upgrade response: %v", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status code %d, got %d", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+	return conn, br
+}
+
+func TestUpgradeTunnel(t *testing.T) {
+	upstream := newEchoUpgradeUpstream()
+	defer upstream.Close()
+
+	rr := &RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}
+	g := NewGag(Config{})
+	g.Conditions().Path("/ws").Method(http.MethodGet).Route(rr, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	conn, br := dialUpgrade(t, s.URL, "/ws")
+	fmt.Fprint(conn, "hello\n")
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Errorf("error reading echo: %v", err)
+		return
+	}
+	if line != "hello\n" {
+		t.Errorf("expected echo %q, got %q", "hello\n", line)
+	}
+
+	if stats := rr.Tunnels(); stats.Active != 1 || stats.Total != 1 {
+		t.Errorf("expected 1 active and 1 total tunnels, got %+v", stats)
+	}
+	conn.Close()
+	for i := 0; i < 100 && rr.Tunnels().Active != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := rr.Tunnels(); stats.Active != 0 {
+		t.Errorf("expected 0 active tunnels after close, got %+v", stats)
+	}
+}
+
+func TestUpgradeIdleTimeout(t *testing.T) {
+	upstream := newEchoUpgradeUpstream()
+	defer upstream.Close()
+
+	g := NewGag(Config{})
+	g.Conditions().Path("/ws").Route(&RouteRequest{Url: upstream.URL, UpgradeIdleTimeout: 100 * time.Millisecond}, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	conn, br := dialUpgrade(t, s.URL, "/ws")
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("expected idle tunnel to be closed, got %v", err)
+	}
+}
+
+func TestUpgradeRefusedByUpstream(t *testing.T) {
+	upstream := newEchoUpgradeUpstream()
+	defer upstream.Close()
+
+	rr := &RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}
+	g := NewGag(Config{})
+	g.Conditions().Path("/ws").Route(rr, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	r, err := http.NewRequest(http.MethodGet, s.URL+"/ws", nil)
+	if err != nil {
+		t.Errorf("error creating request: %v", err)
+		return
+	}
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "unknown")
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Errorf("error doing request: %v", err)
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+	if stats := rr.Tunnels(); stats.Failed != 1 {
+		t.Errorf("expected 1 failed tunnel, got %+v", stats)
+	}
+}
+
+func TestUpgradeOutlivesServerTimeouts(t *testing.T) {
+	upstream := newEchoUpgradeUpstream()
+	defer upstream.Close()
+
+	g := NewGag(Config{ReadTimeout: 100 * time.Millisecond, WriteTimeout: 100 * time.Millisecond})
+	g.Conditions().Path("/ws").Route(&RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet}, g)
+	if _, err := g.Handler(); err != nil {
+		t.Fatalf("error configuring handler: %v", err)
+	}
+	s := httptest.NewUnstartedServer(nil)
+	s.Config = g.httpServer()
+	s.Start()
+	defer s.Close()
+
+	conn, br := dialUpgrade(t, s.URL, "/ws")
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	time.Sleep(300 * time.Millisecond)
+	fmt.Fprint(conn, "hello\n")
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("expected tunnel to outlive server timeouts, got %v", err)
+	}
+	if line != "hello\n" {
+		t.Errorf("expected echo %q, got %q", "hello\n", line)
+	}
+}
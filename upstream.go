@@ -0,0 +1,180 @@
+package gag
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of CircuitBreaker.
+const (
+	DefaultCircuitFailureThreshold = 5
+	DefaultCircuitOpenDuration     = 30 * time.Second
+)
+
+// errCircuitOpen is returned for requests short-circuited by an open circuit.
+var errCircuitOpen = errors.New("circuit open")
+
+// CircuitBreaker contains all properties about how requests to an upstream are short-circuited while it is failing.
+// The circuit opens after FailureThreshold requests failed in a row, and requests are responded with 503
+// without being routed to the upstream. Once OpenDuration has passed, the circuit is half-open and a single request
+// is routed to probe the upstream, closing the circuit when it succeeds and opening it again when it fails.
+// Configure CircuitBreaker by setting RouteRequest.CircuitBreaker.
+type CircuitBreaker struct {
+	// FailureThreshold is the number of requests failed in a row which opens the circuit.
+	// When 0, DefaultCircuitFailureThreshold will be used.
+	FailureThreshold int
+	// OpenDuration is how long the circuit stays open before a request probes the upstream.
+	// When 0, DefaultCircuitOpenDuration will be used.
+	OpenDuration time.Duration
+}
+
+func (cb *CircuitBreaker) validate() error {
+	if cb.FailureThreshold < 0 {
+		return fmt.Errorf("circuit failure threshold(%d) cannot be negative", cb.FailureThreshold)
+	}
+	if cb.OpenDuration < 0 {
+		return fmt.Errorf("circuit open duration(%v) cannot be negative", cb.OpenDuration)
+	}
+	return nil
+}
+
+func (cb *CircuitBreaker) failureThreshold() int {
+	if cb.FailureThreshold == 0 {
+		return DefaultCircuitFailureThreshold
+	}
+	return cb.FailureThreshold
+}
+
+func (cb *CircuitBreaker) openDuration() time.Duration {
+	if cb.OpenDuration == 0 {
+		return DefaultCircuitOpenDuration
+	}
+	return cb.OpenDuration
+}
+
+// CircuitState is the state of the CircuitBreaker of an upstream.
+type CircuitState string
+
+const (
+	// CircuitClosed means requests are routed to the upstream.
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen means requests are short-circuited without being routed to the upstream.
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen means the circuit open duration has passed, so that a single request probes the upstream.
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// UpstreamHealth is the health of an upstream, observed from the responses of the requests routed to it.
+type UpstreamHealth struct {
+	// Healthy is false when the last request to the upstream failed.
+	Healthy bool `json:"healthy"`
+	// Circuit is the state of the CircuitBreaker of the upstream, or "" when it has no CircuitBreaker.
+	Circuit CircuitState `json:"circuit,omitempty"`
+	// ConsecutiveFailures is the number of requests failed in a row.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// LastSuccess is when the last request succeeded.
+	LastSuccess time.Time `json:"lastSuccess"`
+	// LastFailure is when the last request failed.
+	LastFailure time.Time `json:"lastFailure"`
+	// LastError describes the last failure.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// upstreamHealth records the outcomes of requests to an upstream.
+// Transport errors and 5xx responses are failures.
+type upstreamHealth struct {
+	mu                  sync.Mutex
+	consecutiveFailures int
+	lastSuccess         time.Time
+	lastFailure         time.Time
+	lastError           string
+	// circuit is the state of the CircuitBreaker, where "" is CircuitClosed.
+	circuit  CircuitState
+	openedAt time.Time
+	probedAt time.Time
+}
+
+// record records the outcome of a request to the upstream, opening or closing the circuit of cb if it is not nil.
+func (h *upstreamHealth) record(statusCode int, err error, cb *CircuitBreaker) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		// The request body was too large, which says nothing about the upstream.
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil && statusCode >= 500 {
+		err = fmt.Errorf("status code %d", statusCode)
+	}
+	if err != nil {
+		h.consecutiveFailures++
+		h.lastFailure = time.Now()
+		h.lastError = err.Error()
+		if cb != nil && (h.circuit == CircuitHalfOpen || h.consecutiveFailures >= cb.failureThreshold()) {
+			h.circuit = CircuitOpen
+			h.openedAt = h.lastFailure
+		}
+		return
+	}
+	h.consecutiveFailures = 0
+	h.lastSuccess = time.Now()
+	h.circuit = CircuitClosed
+}
+
+// allow returns errCircuitOpen if the circuit of cb is open, or a request is already probing the half-open circuit.
+// A probe whose outcome is not recorded, such as a canceled request, is given up after the open duration.
+func (h *upstreamHealth) allow(cb *CircuitBreaker) error {
+	if cb == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.circuit {
+	case CircuitOpen:
+		if time.Since(h.openedAt) < cb.openDuration() {
+			return errCircuitOpen
+		}
+		h.circuit = CircuitHalfOpen
+	case CircuitHalfOpen:
+		if time.Since(h.probedAt) < cb.openDuration() {
+			return errCircuitOpen
+		}
+	default:
+		return nil
+	}
+	h.probedAt = time.Now()
+	return nil
+}
+
+// snapshot returns the health, along with the state of the circuit of cb if it is not nil.
+func (h *upstreamHealth) snapshot(cb *CircuitBreaker) UpstreamHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var circuit CircuitState
+	if cb != nil {
+		circuit = h.circuit
+		switch {
+		case circuit == "":
+			circuit = CircuitClosed
+		case circuit == CircuitOpen && time.Since(h.openedAt) >= cb.openDuration():
+			// The next request will probe the upstream.
+			circuit = CircuitHalfOpen
+		}
+	}
+	return UpstreamHealth{
+		Healthy:             h.consecutiveFailures == 0,
+		Circuit:             circuit,
+		ConsecutiveFailures: h.consecutiveFailures,
+		LastSuccess:         h.lastSuccess,
+		LastFailure:         h.lastFailure,
+		LastError:           h.lastError,
+	}
+}
+
+// Health returns the health of rr.Url, observed from the requests routed to it.
+func (rr *RouteRequest) Health() UpstreamHealth {
+	return rr.health.snapshot(rr.CircuitBreaker)
+}
@@ -0,0 +1,85 @@
+package gag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int64
+	failing.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+
+	rr := &RouteRequest{Url: upstream.URL, HttpMethod: http.MethodGet, CircuitBreaker: &CircuitBreaker{FailureThreshold: 2, OpenDuration: 100 * time.Millisecond}}
+	g := NewGag(Config{})
+	g.Conditions().Path("/cb").Route(rr, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	steps := []struct {
+		name       string
+		statusCode int
+		calls      int64
+		circuit    CircuitState
+	}{
+		{"first failure", http.StatusInternalServerError, 1, CircuitClosed},
+		{"failure opening the circuit", http.StatusInternalServerError, 2, CircuitOpen},
+		{"short-circuited", http.StatusServiceUnavailable, 2, CircuitOpen},
+		{"failed probe", http.StatusInternalServerError, 3, CircuitOpen},
+		{"succeeded probe", http.StatusOK, 4, CircuitClosed},
+	}
+	for i, step := range steps {
+		switch i {
+		case 3:
+			time.Sleep(150 * time.Millisecond)
+			if circuit := rr.Health().Circuit; circuit != CircuitHalfOpen {
+				t.Errorf("expected half-open circuit after the open duration, got %s", circuit)
+			}
+		case 4:
+			time.Sleep(150 * time.Millisecond)
+			failing.Store(false)
+		}
+		res, err := http.Get(s.URL + "/cb")
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != step.statusCode || calls.Load() != step.calls {
+			t.Errorf("%s: expected status code %d after %d calls, got %d after %d calls", step.name, step.statusCode, step.calls, res.StatusCode, calls.Load())
+		}
+		if circuit := rr.Health().Circuit; circuit != step.circuit {
+			t.Errorf("%s: expected %s circuit, got %s", step.name, step.circuit, circuit)
+		}
+	}
+}
+
+func TestCircuitHalfOpenAllowsSingleProbe(t *testing.T) {
+	cb := &CircuitBreaker{FailureThreshold: 1, OpenDuration: 50 * time.Millisecond}
+	var h upstreamHealth
+	h.record(http.StatusBadGateway, nil, cb)
+	if err := h.allow(cb); err != errCircuitOpen {
+		t.Errorf("expected open circuit, got %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := h.allow(cb); err != nil {
+		t.Errorf("expected a probe to be allowed, got %v", err)
+	}
+	if err := h.allow(cb); err != errCircuitOpen {
+		t.Errorf("expected requests to be short-circuited while probing, got %v", err)
+	}
+	if h.record(http.StatusOK, nil, cb); h.allow(cb) != nil {
+		t.Error("expected closed circuit after a succeeded probe")
+	}
+}
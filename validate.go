@@ -0,0 +1,335 @@
+package gag
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	gorillaMux "github.com/gorilla/mux"
+)
+
+// ValidationError describes a problem of a Condition found before serving.
+type ValidationError struct {
+	// Index is the position of the Condition in the order it was added to Gag.
+	Index int
+	// Path is the path of the Condition.
+	Path string
+	// Method is the HTTP method of the Condition.
+	Method string
+	// Reason describes the problem.
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	method := e.Method
+	if method == "" {
+		method = "*"
+	}
+	return fmt.Sprintf("condition #%d (%s %s): %s", e.Index, method, e.Path, e.Reason)
+}
+
+// ValidationErrors contains all problems found by validating the Conditions of Gag.
+// Use errors.As to obtain ValidationErrors from the error returned by Gag.Serve() or Gag.Handler().
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d invalid condition(s): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns each ValidationError, so that errors.Is and errors.As inspect all of them.
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// pathVariable matches a path variable of a gorilla/mux path template, such as {id} or {id:[0-9]+}.
+var pathVariable = regexp.MustCompile(`\{([^{}:]+)(:[^{}]*)?\}`)
+
+// urlPlaceholder matches a placeholder of RouteRequest.Url, such as {id}.
+var urlPlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// pathVariables returns the names of the path variables of path.
+func pathVariables(path string) []string {
+	var names []string
+	for _, m := range pathVariable.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// urlPlaceholders returns the names of the placeholders of url.
+func urlPlaceholders(url string) []string {
+	var names []string
+	for _, m := range urlPlaceholder.FindAllStringSubmatch(url, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// validateConfig validates the properties of Config which can't be checked by NewGag.
+func (g *Gag) validateConfig() error {
+	trustedProxies, err := parseIPSet(g.cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trusted proxies are invalid: %s", err.Error())
+	}
+	g.trustedProxies = trustedProxies
+	if h := g.cfg.Health; h != nil && (h.UnhealthyUpstreamRatio < 0 || h.UnhealthyUpstreamRatio > 1) {
+		return fmt.Errorf("unhealthy upstream ratio(%v) must be between 0 and 1", h.UnhealthyUpstreamRatio)
+	}
+	if g.cfg.CORS != nil {
+		if err := g.cfg.CORS.validate(); err != nil {
+			return err
+		}
+	}
+	if g.cfg.IPFilter != nil {
+		return g.cfg.IPFilter.validate()
+	}
+	return nil
+}
+
+func (g *Gag) validateConditions() error {
+	return validateConditions(g.conditions)
+}
+
+// validateConditions validates each Condition of conditions and checks whether some of them shadow others.
+// All problems found are returned as ValidationErrors.
+func validateConditions(conditions []*Condition) error {
+	var errs ValidationErrors
+	for i, c := range conditions {
+		for _, reason := range c.validate() {
+			errs = append(errs, &ValidationError{Index: i, Path: c.path, Method: c.httpMethod, Reason: reason})
+		}
+	}
+	for i, c := range conditions {
+		if reason := shadowedBy(conditions[:i], c); reason != "" {
+			errs = append(errs, &ValidationError{Index: i, Path: c.path, Method: c.httpMethod, Reason: reason})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate returns the problems of c which can be found without other Conditions.
+func (c *Condition) validate() []string {
+	var reasons []string
+	if c.path == "" {
+		reasons = append(reasons, "path cannot be \"\"")
+	} else if err := gorillaMux.NewRouter().Path(c.path).GetError(); err != nil {
+		reasons = append(reasons, fmt.Sprintf("path cannot be parsed: %s", err.Error()))
+	}
+	if c.httpMethod != "" && !isValidMethod(c.httpMethod) {
+		reasons = append(reasons, fmt.Sprintf("method(%s) is invalid", c.httpMethod))
+	}
+
+	handlers := 0
+	for _, set := range []bool{c.handlerFunc != nil, c.routeRequest != nil, c.composition != nil, c.grpcRoute != nil} {
+		if set {
+			handlers++
+		}
+	}
+	switch {
+	case handlers == 0:
+		reasons = append(reasons, "neither RouteRequest nor handler function is set")
+	case handlers > 1:
+		reasons = append(reasons, "only one of RouteRequest, handler function, Composition or GrpcRoute can be set")
+	}
+
+	if err := c.phases.validate(); err != nil {
+		reasons = append(reasons, err.Error())
+	}
+	if len(c.upstreamHooks) > 0 && c.routeRequest == nil {
+		reasons = append(reasons, "UpstreamHooks can only be set along with RouteRequest")
+	}
+
+	if c.cors != nil {
+		if err := c.cors.validate(); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+	if c.validation != nil {
+		if err := c.validation.validate(); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+	for _, f := range c.ipFilters {
+		if err := f.validate(); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+
+	if c.routeRequest != nil {
+		if s := c.routeRequest.Split; s != nil {
+			if err := s.validate(); err != nil {
+				reasons = append(reasons, err.Error())
+			}
+		} else if err := validateUpstreamUrl(c.routeRequest.Url, "http", "https", "ws", "wss"); err != nil {
+			reasons = append(reasons, fmt.Sprintf("route url(%s) is invalid: %s", c.routeRequest.Url, err.Error()))
+		}
+		urls := []string{c.routeRequest.Url}
+		if s := c.routeRequest.Split; s != nil {
+			urls = nil
+			for _, v := range s.Versions {
+				urls = append(urls, v.Url)
+			}
+		}
+		for _, u := range urls {
+			for _, name := range urlPlaceholders(u) {
+				if !slices.Contains(pathVariables(c.path), name) {
+					reasons = append(reasons, fmt.Sprintf("route url placeholder(%s) of url(%s) is not a path variable", name, u))
+				}
+			}
+		}
+		if c.routeRequest.HttpMethod != "" && !isValidMethod(c.routeRequest.HttpMethod) {
+			reasons = append(reasons, fmt.Sprintf("route method(%s) is invalid", c.routeRequest.HttpMethod))
+		}
+		if t := c.routeRequest.RequestTransform; t != nil {
+			if !c.routeRequest.PassRequestBody {
+				reasons = append(reasons, "RequestTransform requires PassRequestBody")
+			}
+			if err := t.validate(); err != nil {
+				reasons = append(reasons, err.Error())
+			}
+		}
+		if d := c.routeRequest.Discovery; d != nil {
+			if err := d.validate(); err != nil {
+				reasons = append(reasons, err.Error())
+			}
+			// Discovery replaces the host of the Url, which would route the traffic of the versions to the discovered endpoints.
+			if c.routeRequest.Split != nil {
+				reasons = append(reasons, "discovery cannot be set along with split")
+			} else if u, err := url.Parse(c.routeRequest.Url); err == nil && (u.Scheme == "https" || u.Scheme == "wss") {
+				// TLS would verify the certificate of the upstream against the discovered endpoint instead of the hostname.
+				reasons = append(reasons, fmt.Sprintf("discovery requires an http or ws url, not %s", u.Scheme))
+			}
+		}
+		if cb := c.routeRequest.CircuitBreaker; cb != nil {
+			if err := cb.validate(); err != nil {
+				reasons = append(reasons, err.Error())
+			}
+		}
+		if h := c.routeRequest.Hedge; h != nil {
+			if err := h.validate(c.routeRequest.HttpMethod); err != nil {
+				reasons = append(reasons, err.Error())
+			}
+		}
+		if m := c.routeRequest.Mirror; m != nil {
+			if err := m.validate(); err != nil {
+				reasons = append(reasons, err.Error())
+			}
+		}
+		if t := c.routeRequest.ResponseTransform; t != nil {
+			if err := t.validate(); err != nil {
+				reasons = append(reasons, err.Error())
+			}
+		}
+	}
+	if c.composition != nil {
+		if err := c.composition.validate(); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+		for _, call := range c.composition.Calls {
+			if call.HttpMethod != "" && !isValidMethod(call.HttpMethod) {
+				reasons = append(reasons, fmt.Sprintf("composition call(%s) method(%s) is invalid", call.Name, call.HttpMethod))
+			}
+		}
+	}
+	if c.grpcRoute != nil {
+		if err := validateUpstreamUrl(c.grpcRoute.Url, "http", "https"); err != nil {
+			reasons = append(reasons, fmt.Sprintf("gRPC url(%s) is invalid: %s", c.grpcRoute.Url, err.Error()))
+		}
+	}
+	return reasons
+}
+
+// validateUpstreamUrl checks whether rawUrl is an absolute url having one of schemes.
+func validateUpstreamUrl(rawUrl string, schemes ...string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("url must be absolute")
+	}
+	if !hasToken(schemes, u.Scheme) {
+		return fmt.Errorf("scheme must be one of %s", strings.Join(schemes, ", "))
+	}
+	return nil
+}
+
+// shadowedBy reports why c can never be reached because of the Conditions registered before it, or "" if it can.
+// Gag matches requests by path first, so an earlier Condition matching the path of c handles all requests for it,
+// regardless of their HTTP methods and headers, except for the Conditions of an OpenAPI path item.
+func shadowedBy(earlier []*Condition, c *Condition) string {
+	if c.path == "" || c.disabled || gorillaMux.NewRouter().Path(c.path).GetError() != nil {
+		return ""
+	}
+	template := normalizePathTemplate(c.path)
+	sample, ok := samplePath(c.path)
+	for i, e := range earlier {
+		if e.path == "" || e.disabled {
+			continue
+		}
+		if c.pathItem != nil && e.pathItem == c.pathItem && e.path == c.path && e.httpMethod != c.httpMethod {
+			// The operations of an OpenAPI path item are dispatched by HTTP method.
+			continue
+		}
+		if normalizePathTemplate(e.path) == template {
+			return fmt.Sprintf("unreachable, since condition #%d has the same path", i)
+		}
+		if !ok {
+			continue
+		}
+		route := gorillaMux.NewRouter().Path(e.path)
+		if route.GetError() != nil {
+			continue
+		}
+		var match gorillaMux.RouteMatch
+		if route.Match(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: sample}}, &match) {
+			return fmt.Sprintf("shadowed by condition #%d (%s), which matches its paths", i, e.path)
+		}
+	}
+	return ""
+}
+
+// normalizePathTemplate removes the names of path variables from path,
+// so that templates matching the same paths compare equal.
+func normalizePathTemplate(path string) string {
+	return pathVariable.ReplaceAllString(path, "{$2}")
+}
+
+// samplePath returns a path matched by the template path, if its variables are not restricted by patterns.
+func samplePath(path string) (string, bool) {
+	ok := true
+	sample := pathVariable.ReplaceAllStringFunc(path, func(v string) string {
+		if strings.Contains(v, ":") {
+			ok = false
+		}
+		return "gag-sample"
+	})
+	return sample, ok
+}
+
+// isValidMethod reports whether method is a valid HTTP method token.
+func isValidMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for _, r := range method {
+		if r < '!' || r > '~' || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package gag
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	split := &TrafficSplit{Versions: []*UpstreamVersion{
+		{Name: "stable", Url: "http://127.0.0.1:8081"},
+		{Name: "canary", Url: "http://127.0.0.1:8082"},
+	}}
+	templated := &TrafficSplit{Versions: []*UpstreamVersion{
+		{Name: "stable", Url: "http://127.0.0.1:8081/items/{id}", Weight: 1},
+		{Name: "canary", Url: "http://127.0.0.1:8082/items/{itemId}", Weight: 1},
+	}}
+	weighted := &TrafficSplit{Versions: []*UpstreamVersion{
+		{Name: "stable", Url: "http://127.0.0.1:8081", Weight: 9},
+		{Name: "canary", Url: "http://127.0.0.1:8082", Weight: 1},
+	}}
+	discovery := &Discovery{Provider: &FileProvider{Path: "endpoints"}}
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/relative").Route(&RouteRequest{Url: "/route-to", HttpMethod: http.MethodGet}, g).
+		Path("/bad-method").Method("GE T").HandlerFunc(sampleHandler(), g).
+		Path("/users/{id").HandlerFunc(sampleHandler(), g).
+		Path("/users/{id}").Method(http.MethodGet).HandlerFunc(sampleHandler(), g).
+		Path("/users/{userId}").Method(http.MethodDelete).HandlerFunc(sampleHandler(), g).
+		Path("/files/{name}").HandlerFunc(sampleHandler(), g).
+		Path("/files/readme").HandlerFunc(sampleHandler(), g).
+		Path("/grpc").Grpc(&GrpcRoute{Url: "ftp://127.0.0.1"}, g).
+		Path("/canary").Route(&RouteRequest{Split: split}, g).
+		Path("/hedged").Route(&RouteRequest{Url: "http://127.0.0.1:8081", HttpMethod: http.MethodPost, Hedge: &Hedge{}}, g).
+		Path("/items/{id}").Route(&RouteRequest{Url: "http://127.0.0.1:8081/items/{name}", HttpMethod: http.MethodGet}, g).
+		Path("/split-items/{id}").Route(&RouteRequest{Split: templated, HttpMethod: http.MethodGet}, g).
+		Path("/continue").Route(&RouteRequest{Url: "http://127.0.0.1:8081", HttpMethod: http.MethodGet, ResponseTransform: &ResponseTransform{
+		StatusCodes: map[int]int{http.StatusOK: http.StatusContinue},
+	}}, g).
+		Path("/discovered-canary").Route(&RouteRequest{Split: weighted, HttpMethod: http.MethodGet, Discovery: discovery}, g).
+		Path("/discovered-tls").Route(&RouteRequest{Url: "https://users.internal", HttpMethod: http.MethodGet, Discovery: discovery}, g)
+
+	_, err := g.Handler()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	expected := map[int]string{
+		0:  "route url(/route-to) is invalid",
+		1:  "method(GE T) is invalid",
+		2:  "path cannot be parsed",
+		4:  "unreachable, since condition #3 has the same path",
+		6:  "shadowed by condition #5",
+		7:  "gRPC url(ftp://127.0.0.1) is invalid",
+		8:  "sum of split weights must be positive",
+		9:  "hedge requires an idempotent route method",
+		10: "route url placeholder(name) of url(http://127.0.0.1:8081/items/{name}) is not a path variable",
+		11: "route url placeholder(itemId) of url(http://127.0.0.1:8082/items/{itemId}) is not a path variable",
+		12: "status code(100) mapped from 200 is invalid",
+		13: "discovery cannot be set along with split",
+		14: "discovery requires an http or ws url, not https",
+	}
+	if len(errs) != len(expected) {
+		t.Errorf("expected %d problems, got %d: %v", len(expected), len(errs), err)
+	}
+	for _, e := range errs {
+		reason, ok := expected[e.Index]
+		if !ok || !strings.Contains(e.Reason, reason) {
+			t.Errorf("unexpected problem: %v", e)
+		}
+	}
+}
+
+func TestValidateAcceptsDistinctRoutes(t *testing.T) {
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/files/readme").HandlerFunc(sampleHandler(), g).
+		Path("/files/{name}").HandlerFunc(sampleHandler(), g).
+		Path("/users/{id:[0-9]+}").HandlerFunc(sampleHandler(), g).
+		Path("/users/{name:[a-z]+}").HandlerFunc(sampleHandler(), g).
+		Path("/upstream").Route(&RouteRequest{Url: "http://127.0.0.1:8081/upstream", HttpMethod: http.MethodGet}, g)
+
+	if _, err := g.Handler(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestAdminRejectsConflictingRoute(t *testing.T) {
+	g := NewGag(Config{Admin: &AdminConfig{Token: "secret"}})
+	g.Conditions().Path("/a").Method(http.MethodGet).HandlerFunc(sampleHandler(), g)
+	g.configureHandler()
+	admin := httptest.NewServer(g.adminHandler())
+	defer admin.Close()
+
+	res := doAdmin(t, admin.URL, http.MethodPost, "/routes", `{"path":"/a","method":"POST","upstream":{"url":"http://127.0.0.1:8081/a"}}`)
+	res.Body.Close()
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, res.StatusCode)
+	}
+	if len(g.conditions) != 1 {
+		t.Errorf("expected conflicting route not to be added, got %d conditions", len(g.conditions))
+	}
+}
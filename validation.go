@@ -0,0 +1,254 @@
+package gag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	gorillaMux "github.com/gorilla/mux"
+)
+
+// DefaultValidationMaxBodyBytes is the maximum size of the bodies read for validation,
+// when RequestValidation.MaxBodyBytes is 0.
+const DefaultValidationMaxBodyBytes = 1 << 20
+
+// RequestValidation contains all properties about how the requests of a Condition are validated
+// before they reach the handler. Requests violating them are responded with 400, listing every Violation,
+// and are not forwarded to the upstream.
+// Configure RequestValidation using Condition.Validate() method, or OpenAPIRoutes.Validate.
+type RequestValidation struct {
+	// Operation validates the path, query, header and cookie parameters, and the body of the requests.
+	// Bodies are validated for JSON content types only, while the content type is checked against all of Operation's.
+	Operation *OpenAPIOperation
+	// Body validates JSON bodies of the requests, in addition to the request body of Operation.
+	Body *Schema
+	// MaxBodyBytes is the maximum size of the bodies read for validation. Larger bodies are responded with 413.
+	// Bodies are read only when Body or the request body of Operation is set.
+	// When 0, DefaultValidationMaxBodyBytes will be used.
+	MaxBodyBytes int64
+}
+
+// Violation is a part of a request violating its RequestValidation.
+type Violation struct {
+	// In is where the violating part is: "path", "query", "header", "cookie" or "body".
+	In string `json:"in"`
+	// Name is the name of the parameter, or the JSON pointer of the field of the body, such as "/items/0/name".
+	Name string `json:"name,omitempty"`
+	// Message describes the violation, such as "is required".
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Name == "" {
+		return fmt.Sprintf("%s %s", v.In, v.Message)
+	}
+	return fmt.Sprintf("%s(%s) %s", v.In, v.Name, v.Message)
+}
+
+// Validate sets Condition's validation property. Requests violating validation are responded with 400
+// before the PhasePreUpstream middlewares run.
+// Example:
+//  schema, err := gag.ParseSchema([]byte(`{"type":"object","required":["name"]}`))
+//  g.Conditions().Path("/users").Method(http.MethodPost).Validate(&gag.RequestValidation{Body: schema}).Route(...)
+func (c *Condition) Validate(validation *RequestValidation) *Condition {
+	c.validation = validation
+	return c
+}
+
+func (v *RequestValidation) validate() error {
+	if v.Operation == nil && v.Body == nil {
+		return errors.New("request validation requires Operation or Body")
+	}
+	if v.MaxBodyBytes < 0 {
+		return fmt.Errorf("request validation max body bytes(%d) cannot be negative", v.MaxBodyBytes)
+	}
+	return nil
+}
+
+// middleware returns a Middleware which passes only the requests valid against v.
+func (v *RequestValidation) middleware() Middleware {
+	readsBody := v.Body != nil || (v.Operation != nil && v.Operation.RequestBody != nil)
+	maxBodyBytes := v.MaxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = DefaultValidationMaxBodyBytes
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body []byte
+			if readsBody && r.Body != nil {
+				if r.ContentLength > maxBodyBytes {
+					respond413(w, r, maxBodyBytes)
+					return
+				}
+				var err error
+				if body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes)); err != nil {
+					var maxBytesErr *http.MaxBytesError
+					if errors.As(err, &maxBytesErr) {
+						respond413(w, r, maxBytesErr.Limit)
+					} else {
+						respond400(w, r, err)
+					}
+					return
+				}
+				r.Body.Close()
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			if violations := v.violations(r, body); len(violations) > 0 {
+				respond400Violations(w, r, violations)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// violations returns every Violation of r, whose body is body.
+func (v *RequestValidation) violations(r *http.Request, body []byte) []Violation {
+	var violations []Violation
+	if op := v.Operation; op != nil {
+		for _, p := range op.Parameters {
+			violations = append(violations, p.violations(r)...)
+		}
+		if op.RequestBody != nil {
+			violations = append(violations, op.RequestBody.violations(r, body)...)
+		}
+	}
+	if v.Body != nil {
+		violations = append(violations, validateJSONBody(v.Body, body)...)
+	}
+	return violations
+}
+
+// violations returns the Violations of the parameter p by r.
+func (p *OpenAPIParameter) violations(r *http.Request) []Violation {
+	var values []string
+	switch p.In {
+	case "path":
+		if value, ok := gorillaMux.Vars(r)[p.Name]; ok {
+			values = []string{value}
+		}
+	case "query":
+		values = r.URL.Query()[p.Name]
+	case "header":
+		values = r.Header.Values(p.Name)
+	case "cookie":
+		if c, err := r.Cookie(p.Name); err == nil {
+			values = []string{c.Value}
+		}
+	}
+	if len(values) == 0 {
+		if p.Required {
+			return []Violation{{In: p.In, Name: p.Name, Message: "is required"}}
+		}
+		return nil
+	}
+	if p.Schema == nil {
+		return nil
+	}
+	var violations []Violation
+	for _, err := range p.Schema.validate(parameterValue(p.Schema, values), "") {
+		name := p.Name
+		if err.pointer != "" {
+			name += err.pointer
+		}
+		violations = append(violations, Violation{In: p.In, Name: name, Message: err.message})
+	}
+	return violations
+}
+
+// parameterValue converts the string values of a parameter to the type of schema,
+// so that they are validated as JSON values. Values which can't be converted are kept as strings,
+// to be reported as violating the type.
+func parameterValue(schema *Schema, values []string) interface{} {
+	for schema.resolved != nil {
+		schema = schema.resolved
+	}
+	if slices.Contains(schema.Type, "array") {
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		items := make([]interface{}, len(values))
+		for i, value := range values {
+			items[i] = value
+			if schema.Items != nil {
+				items[i] = parameterValue(schema.Items, []string{value})
+			}
+		}
+		return items
+	}
+	value := values[0]
+	switch {
+	case slices.Contains(schema.Type, "integer"), slices.Contains(schema.Type, "number"):
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case slices.Contains(schema.Type, "boolean"):
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// violations returns the Violations of rb by r, whose body is body.
+func (rb *OpenAPIRequestBody) violations(r *http.Request, body []byte) []Violation {
+	if len(body) == 0 {
+		if rb.Required {
+			return []Violation{{In: "body", Message: "is required"}}
+		}
+		return nil
+	}
+	if len(rb.Content) == 0 {
+		return nil
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	mediaType, ok := rb.mediaType(contentType)
+	if !ok {
+		return []Violation{{In: "header", Name: "Content-Type", Message: fmt.Sprintf("must be one of %s", strings.Join(sortedKeys(rb.Content), ", "))}}
+	}
+	if mediaType == nil || mediaType.Schema == nil || !isJSONContentType(contentType) {
+		return nil
+	}
+	return validateJSONBody(mediaType.Schema, body)
+}
+
+// mediaType returns the media type of rb matching contentType, including wildcards such as "application/*".
+func (rb *OpenAPIRequestBody) mediaType(contentType string) (*OpenAPIMediaType, bool) {
+	if mediaType, ok := rb.Content[contentType]; ok {
+		return mediaType, true
+	}
+	if i := strings.Index(contentType, "/"); i >= 0 {
+		if mediaType, ok := rb.Content[contentType[:i]+"/*"]; ok {
+			return mediaType, true
+		}
+	}
+	mediaType, ok := rb.Content["*/*"]
+	return mediaType, ok
+}
+
+func isJSONContentType(contentType string) bool {
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+// validateJSONBody returns the Violations of schema by body.
+func validateJSONBody(schema *Schema, body []byte) []Violation {
+	if len(body) == 0 {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []Violation{{In: "body", Message: "must be valid JSON"}}
+	}
+	var violations []Violation
+	for _, err := range schema.validate(value, "") {
+		violations = append(violations, Violation{In: "body", Name: err.pointer, Message: err.message})
+	}
+	return violations
+}
@@ -0,0 +1,203 @@
+package gag
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testValidationOpenAPI = `{
+	"openapi": "3.0.3",
+	"paths": {
+		"/users/{id}": {
+			"parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 1}}],
+			"put": {
+				"operationId": "updateUser",
+				"parameters": [
+					{"$ref": "#/components/parameters/dryRun"},
+					{"name": "X-Request-Id", "in": "header", "required": true, "schema": {"type": "string"}}
+				],
+				"requestBody": {"$ref": "#/components/requestBodies/user"}
+			}
+		}
+	},
+	"components": {
+		"parameters": {
+			"dryRun": {"name": "dryRun", "in": "query", "schema": {"type": "boolean"}}
+		},
+		"requestBodies": {
+			"user": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/user"}}}}
+		},
+		"schemas": {
+			"user": {
+				"type": "object",
+				"required": ["name"],
+				"additionalProperties": false,
+				"properties": {
+					"name": {"type": "string", "minLength": 1},
+					"roles": {"type": "array", "items": {"enum": ["admin", "member"]}}
+				}
+			}
+		}
+	}
+}`
+
+func TestValidateOpenAPIOperation(t *testing.T) {
+	upstream := httptest.NewServer(jsonHandler(`{"updated":true}`))
+	defer upstream.Close()
+
+	doc, err := ParseOpenAPI([]byte(testValidationOpenAPI))
+	if err != nil {
+		t.Fatalf("error parsing OpenAPI: %v", err)
+	}
+	g := NewGag(Config{ErrorHandler: ProblemJSON})
+	if err := g.ImportOpenAPI(doc, &OpenAPIRoutes{Upstream: upstream.URL, Validate: true}); err != nil {
+		t.Fatalf("error importing OpenAPI: %v", err)
+	}
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		requestID   string
+		body        string
+		violations  []Violation
+	}{
+		{"valid", "/users/7?dryRun=true", "application/json", "1", `{"name":"sang","roles":["admin"]}`, nil},
+		{"invalid parameters", "/users/0?dryRun=maybe", "application/json", "", `{"name":"sang"}`, []Violation{
+			{In: "path", Name: "id", Message: "must be at least 1"},
+			{In: "query", Name: "dryRun", Message: "must be of type boolean"},
+			{In: "header", Name: "X-Request-Id", Message: "is required"},
+		}},
+		{"invalid body", "/users/7", "application/json", "1", `{"roles":["owner"],"age":3}`, []Violation{
+			{In: "body", Name: "/name", Message: "is required"},
+			{In: "body", Name: "/age", Message: "is not allowed"},
+			{In: "body", Name: "/roles/0", Message: "must be one of the enum values"},
+		}},
+		{"missing body", "/users/7", "application/json", "1", "", []Violation{{In: "body", Message: "is required"}}},
+		{"unsupported content type", "/users/7", "text/plain", "1", "name", []Violation{{In: "header", Name: "Content-Type", Message: "must be one of application/json"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodPut, s.URL+tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			if tt.requestID != "" {
+				r.Header.Set("X-Request-Id", tt.requestID)
+			}
+			res, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatalf("error doing request: %v", err)
+			}
+			if tt.violations == nil {
+				if err := validateResponse(res, http.StatusOK, `{"updated":true}`); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if res.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status code 400, got %d", res.StatusCode)
+			}
+			var problem Problem
+			decodeJSON(t, res, &problem)
+			if problem.Type != ProblemTypePrefix+string(ErrorInvalidRequest) {
+				t.Errorf("unexpected problem type %s", problem.Type)
+			}
+			if !reflect.DeepEqual(problem.Violations, tt.violations) {
+				t.Errorf("expected violations %+v, got %+v", tt.violations, problem.Violations)
+			}
+		})
+	}
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"$defs": {
+			"node": {
+				"type": "object",
+				"required": ["value"],
+				"properties": {
+					"value": {"type": ["integer", "null"]},
+					"children": {"type": "array", "maxItems": 2, "items": {"$ref": "#/$defs/node"}}
+				}
+			}
+		},
+		"$ref": "#/$defs/node"
+	}`))
+	if err != nil {
+		t.Fatalf("error parsing schema: %v", err)
+	}
+	g := NewGag(Config{})
+	g.Conditions().Path("/trees").Method(http.MethodPost).Validate(&RequestValidation{Body: schema}).HandlerFunc(sampleHandler(), g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	tests := []struct {
+		body       string
+		statusCode int
+		expected   string
+	}{
+		{`{"value":1,"children":[{"value":null},{"value":2,"children":[]}]}`, http.StatusOK, `{"message":"sample handler!"}`},
+		{`{"value":1.5,"children":[{},{"value":1},{"value":2}]}`, http.StatusBadRequest,
+			"400 request is invalid: body(/children) must have at most 2 items; body(/children/0/value) is required; body(/value) must be of type integer or null"},
+		{`{"value":`, http.StatusBadRequest, "400 request is invalid: body must be valid JSON"},
+	}
+	for _, tt := range tests {
+		res, err := http.Post(s.URL+"/trees", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		if err := validateResponse(res, tt.statusCode, tt.expected); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+		}
+	}
+}
+
+func TestValidationBodyLimit(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{"type":"object"}`))
+	if err != nil {
+		t.Fatalf("error parsing schema: %v", err)
+	}
+	echoLength := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(strconv.Itoa(len(body))))
+	}
+	g := NewGag(Config{})
+	g.Conditions().
+		Path("/small").Method(http.MethodPost).Validate(&RequestValidation{Body: schema, MaxBodyBytes: 16}).HandlerFunc(echoLength, g).
+		Path("/default").Method(http.MethodPost).Validate(&RequestValidation{Body: schema}).HandlerFunc(echoLength, g).
+		Path("/params").Method(http.MethodPost).Validate(&RequestValidation{Operation: &OpenAPIOperation{
+		Parameters: []*OpenAPIParameter{{Name: "dryRun", In: "query"}},
+	}}).HandlerFunc(echoLength, g)
+	s := newTestServer(g)
+	defer s.Close()
+
+	large := `{"data":"` + strings.Repeat("a", DefaultValidationMaxBodyBytes) + `"}`
+	tests := []struct {
+		path       string
+		body       string
+		statusCode int
+		expected   string
+	}{
+		{"/small", `{"data":"small"}`, http.StatusOK, "16"},
+		{"/small", `{"data":"larger"}`, http.StatusRequestEntityTooLarge, "413 request body larger than 16 bytes"},
+		{"/default", large, http.StatusRequestEntityTooLarge, fmt.Sprintf("413 request body larger than %d bytes", DefaultValidationMaxBodyBytes)},
+		// Bodies are not read when only parameters are validated.
+		{"/params", large, http.StatusOK, strconv.Itoa(len(large))},
+	}
+	for _, tt := range tests {
+		res, err := http.Post(s.URL+tt.path, "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("error doing request: %v", err)
+		}
+		if err := validateResponse(res, tt.statusCode, tt.expected); err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		}
+	}
+}